@@ -7,6 +7,12 @@ const LUA_RIDX_MAINTHREAD int64 = 1
 const LUA_RIDX_GLOBALS int64 = 2
 const LUA_MULTRET = -1
 
+/* predefined references */
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
+)
+
 const (
 	LUA_MAXINTEGER = 1<<63 - 1
 	LUA_MININTEGER = -1 << 63
@@ -62,3 +68,20 @@ const (
 	LUA_ERRERR
 	LUA_ERRFILE
 )
+
+/* event codes */
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+/* event masks */
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
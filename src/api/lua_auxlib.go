@@ -6,7 +6,9 @@ type FuncReg map[string]GoFunction
 type AuxLib interface {
 	/* Error-report functions */
 	Error2(fmt string, a ...interface{}) int
+	ErrorE(err error) int
 	ArgError(arg int, extraMsg string) int
+	Where(lvl int)
 	/* Argument check functions */
 	CheckStack2(sz int, msg string)
 	ArgCheck(cond bool, arg int, extraMsg string)
@@ -18,9 +20,14 @@ type AuxLib interface {
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
+	CheckOption(arg int, def string, lst []string) int
+	TestUdata(arg int, tname string) interface{}
+	CheckUdata(arg int, tname string) interface{}
 	/* Load functions */
 	DoFile(filename string) bool
 	DoString(str string) bool
+	DoFileE(filename string) error
+	DoStringE(str string) error
 	LoadFile(filename string) int
 	LoadFileX(filename, mode string) int
 	LoadString(s string) int
@@ -31,7 +38,14 @@ type AuxLib interface {
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LuaType
 	CallMeta(obj int, e string) bool
+	NewMetatable(tname string) bool
+	GetMetatable2(tname string) LuaType
+	SetMetatable2(tname string)
+	Ref(t int) int
+	Unref(t, ref int)
+	Traceback(L1 LuaState, msg string, level int) string
 	OpenLibs()
+	OpenSandbox(sb *Sandbox)
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(l FuncReg)
 	NewLibTable(l FuncReg)
@@ -0,0 +1,26 @@
+package api
+
+// LuaHook is called by the VM on the events selected with SetHook.
+// Line and count hooks may raise errors, or yield with no values.
+type LuaHook func(ls LuaState, ar *LuaDebug)
+
+// LuaDebug carries information about a function or an activation record,
+// it is filled by GetStack and GetInfo.
+// http://www.lua.org/manual/5.3/manual.html#lua_Debug
+type LuaDebug struct {
+	Event           int
+	Name            string /* (n) */
+	NameWhat        string /* (n) 'global', 'local', 'field', 'method' */
+	What            string /* (S) 'Lua', 'C', 'main' */
+	Source          string /* (S) */
+	CurrentLine     int    /* (l) */
+	LineDefined     int    /* (S) */
+	LastLineDefined int    /* (S) */
+	NUps            int    /* (u) number of upvalues */
+	NParams         int    /* (u) number of parameters */
+	IsVararg        bool   /* (u) */
+	IsTailCall      bool   /* (t) */
+	ShortSrc        string /* (S) */
+	/* private part */
+	CallInfo interface{} /* active function */
+}
@@ -0,0 +1,45 @@
+package api
+
+import "errors"
+
+// ErrInstructionLimit is the cause of the error raised when a script
+// runs more instructions than allowed by SetInstructionLimit.
+var ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+// ErrMemoryLimit is the cause of LUA_ERRMEM errors raised when a state
+// uses more memory than allowed by SetMemoryLimit.
+var ErrMemoryLimit = errors.New("not enough memory")
+
+// LuaError is returned by the Go-facing protected call functions
+// (PCallE, DoStringE, DoFileE) when running Lua code fails.
+type LuaError struct {
+	Status    int         // LUA_ERRRUN, LUA_ERRSYNTAX, LUA_ERRMEM or LUA_ERRERR
+	Value     interface{} // the error object raised by Lua
+	Message   string      // the error object converted to a string
+	Position  string      // chunk:line of the innermost Lua function, if any
+	Traceback string      // stack traceback taken where the error was raised
+	GoPanic   interface{} // the recovered value if a Go function panicked
+	Cause     error       // ErrInstructionLimit, ErrMemoryLimit, ctx.Err() or the error given to ErrorE
+}
+
+func (self *LuaError) Error() string {
+	return self.Message
+}
+
+// IsGoPanic reports whether the error was caused by a Go runtime
+// panic (nil dereference, index out of range...) rather than by Lua.
+func (self *LuaError) IsGoPanic() bool {
+	return self.GoPanic != nil
+}
+
+// Unwrap returns the cause of an interrupted script
+// or the recovered Go error, if any.
+func (self *LuaError) Unwrap() error {
+	if self.Cause != nil {
+		return self.Cause
+	}
+	if err, ok := self.GoPanic.(error); ok {
+		return err
+	}
+	return nil
+}
@@ -1,11 +1,21 @@
 package api
 
+import "context"
+import "io"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
 
 type GoFunction func(LuaState) int
 
+// KContext is passed unchanged to the continuation of a Go function.
+type KContext int
+
+// KFunction continues a Go function after a callee (or the function
+// itself) yielded; status is LUA_YIELD, or the error status for PCallK.
+type KFunction func(ls LuaState, status int, ctx KContext) int
+
 func LuaUpvalueIndex(i int) int {
 	return LUA_REGISTRYINDEX - i
 }
@@ -43,6 +53,8 @@ type BasicAPI interface {
 	IsThread(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -52,6 +64,7 @@ type BasicAPI interface {
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
 	ToThread(idx int) LuaState
+	ToUserdata(idx int) interface{}
 	ToPointer(idx int) interface{}
 	RawLen(idx int) uint
 	/* push functions (Go -> stack) */
@@ -63,6 +76,7 @@ type BasicAPI interface {
 	PushFString(fmt string, a ...interface{})
 	PushGoFunction(f GoFunction)
 	PushGoClosure(f GoFunction, n int)
+	PushLightUserdata(p interface{})
 	PushGlobalTable()
 	PushThread() bool
 	/* Comparison and arithmetic functions */
@@ -72,6 +86,7 @@ type BasicAPI interface {
 	/* get functions (Lua -> stack) */
 	NewTable()
 	CreateTable(nArr, nRec int)
+	NewUserdata(data interface{})
 	GetTable(idx int) LuaType
 	GetField(idx int, k string) LuaType
 	GetI(idx int, i int64) LuaType
@@ -79,6 +94,7 @@ type BasicAPI interface {
 	RawGetI(idx int, i int64) LuaType
 	GetMetatable(idx int) bool
 	GetGlobal(name string) LuaType
+	GetUserValue(idx int) LuaType
 	/* set functions (stack -> Lua) */
 	SetTable(idx int)
 	SetField(idx int, k string)
@@ -87,11 +103,25 @@ type BasicAPI interface {
 	RawSetI(idx int, i int64)
 	SetMetatable(idx int)
 	SetGlobal(name string)
+	SetUserValue(idx int)
 	Register(name string, f GoFunction)
 	/* 'load' and 'call' functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) int
+	LoadReader(r io.Reader, chunkName, mode string) int
+	Dump(strip bool) []byte
 	Call(nArgs, nResults int)
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)
 	PCall(nArgs, nResults, msgh int) int
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int
+	PCallE(nArgs, nResults int) error
+	/* execution limits */
+	SetContext(ctx context.Context)
+	Context() context.Context
+	SetInstructionLimit(n int64)
+	InstructionCount() int64
+	SetMemoryLimit(limit int64)
+	MemoryUsage() int64
+	CheckMemory(n int64)
 	/* miscellaneous functions */
 	Len(idx int)
 	Concat(n int)
@@ -102,7 +132,21 @@ type BasicAPI interface {
 	NewThread() LuaState
 	Resume(from LuaState, nArgs int) int
 	Yield(nResults int) int
+	YieldK(nResults int, ctx KContext, k KFunction) int
 	Status() int
 	IsYieldable() bool
-	GetStack() bool // debug
+	CloseThread(from LuaState) int
+	/* debug API */
+	GetStack(level int, ar *LuaDebug) bool
+	GetInfo(what string, ar *LuaDebug) bool
+	GetLocal(ar *LuaDebug, n int) (string, bool)
+	SetLocal(ar *LuaDebug, n int) (string, bool)
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	UpvalueID(funcIdx, n int) interface{}
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+	SetHook(f LuaHook, mask, count int)
+	GetHook() LuaHook
+	GetHookMask() int
+	GetHookCount() int
 }
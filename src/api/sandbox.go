@@ -0,0 +1,45 @@
+package api
+
+// Sandbox describes what a state opened with OpenSandbox exposes
+// to the scripts it runs.
+type Sandbox struct {
+	// Libs lists the libraries to open: "_G", "coroutine", "debug",
+	// "io", "math", "os", "package", "string", "table" or "utf8".
+	Libs []string
+	// Hide lists globals and library functions to remove once the
+	// libraries are opened, e.g. "print" or "os.getenv".
+	Hide []string
+	// LoadMode limits the chunks Load (and load) accept, whatever mode
+	// the caller asks for: "t" for text, "b" for binary or "bt" for both.
+	LoadMode string
+	// FileAccess keeps dofile, loadfile and the package functions that
+	// search the file system. Preloaded modules can always be required.
+	FileAccess bool
+	// HideRawAccess removes getmetatable, rawget and rawset, so that
+	// scripts cannot bypass or change the metatables set up by the host.
+	HideRawAccess bool
+}
+
+// SafeSandbox opens every library but debug and io, keeps scripts
+// away from the file system, the environment and the process, and
+// only loads text chunks; string.dump, which could show the code of
+// the functions given by the host, is removed.
+func SafeSandbox() *Sandbox {
+	return &Sandbox{
+		Libs: []string{"_G", "coroutine", "math", "os", "package", "string", "table", "utf8"},
+		Hide: []string{"os.execute", "os.exit", "os.getenv", "os.remove",
+			"os.rename", "os.setlocale", "os.tmpname", "string.dump"},
+		LoadMode: "t",
+	}
+}
+
+// StrictSandbox is SafeSandbox without os and package,
+// and scripts cannot get around metatables.
+func StrictSandbox() *Sandbox {
+	return &Sandbox{
+		Libs:          []string{"_G", "coroutine", "math", "string", "table", "utf8"},
+		Hide:          []string{"string.dump"},
+		LoadMode:      "t",
+		HideRawAccess: true,
+	}
+}
@@ -1,5 +1,8 @@
 package binchunk
 
+import "bytes"
+import "io"
+
 const (
 	LUA_SIGNATURE    = "\x1bLua"
 	LUAC_VERSION     = 0x53
@@ -76,9 +79,40 @@ func IsBinaryChunk(data []byte) bool {
 		string(data[:4]) == LUA_SIGNATURE
 }
 
+// FormatError reports a malformed or incompatible binary chunk.
+type FormatError struct {
+	Why string // "truncated", "corrupted", "version mismatch in"...
+}
+
+func (self *FormatError) Error() string {
+	return self.Why + " precompiled chunk"
+}
+
 func Undump(data []byte) *Prototype {
-	reader := &reader{data}
+	proto, err := UndumpReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err.Error())
+	}
+	return proto
+}
+
+// UndumpReader reads a binary chunk from r without buffering it first.
+// It returns a *FormatError if the chunk is malformed, or the error
+// returned by r.
+func UndumpReader(r io.Reader) (proto *Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			re, ok := r.(readError)
+			if !ok {
+				panic(r)
+			}
+			err = re.err
+		}
+	}()
+
+	reader := &reader{r: r}
 	reader.checkHeader()
 	reader.readByte() // size_upvalues
-	return reader.readProto("")
+	/* the source of a stripped chunk is unknown */
+	return reader.readProto("=?"), nil
 }
@@ -1,34 +1,67 @@
 package binchunk
 
 import "encoding/binary"
+import "io"
 import "math"
 
+// counts read from a chunk are not trusted for preallocation beyond this
+const maxPrealloc = 1 << 16
+
+// raised by the reader, turned into an error by UndumpReader
+type readError struct {
+	err error
+}
+
 type reader struct {
-	data []byte
+	r   io.Reader
+	buf [8]byte
+}
+
+func (self *reader) read(p []byte) {
+	if _, err := io.ReadFull(self.r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = &FormatError{"truncated"}
+		}
+		panic(readError{err})
+	}
+}
+
+func (self *reader) error(why string) {
+	panic(readError{&FormatError{why}})
 }
 
 func (self *reader) readByte() byte {
-	b := self.data[0]
-	self.data = self.data[1:]
-	return b
+	self.read(self.buf[:1])
+	return self.buf[0]
 }
 
 func (self *reader) readBytes(n uint) []byte {
-	bytes := self.data[:n]
-	self.data = self.data[n:]
+	if n <= maxPrealloc {
+		bytes := make([]byte, n)
+		self.read(bytes)
+		return bytes
+	}
+	/* grow as data arrives, the size may be corrupted */
+	var bytes []byte
+	for n > 0 {
+		m := n
+		if m > maxPrealloc {
+			m = maxPrealloc
+		}
+		bytes = append(bytes, self.readBytes(m)...)
+		n -= m
+	}
 	return bytes
 }
 
 func (self *reader) readUint32() uint32 {
-	i := binary.LittleEndian.Uint32(self.data)
-	self.data = self.data[4:]
-	return i
+	self.read(self.buf[:4])
+	return binary.LittleEndian.Uint32(self.buf[:4])
 }
 
 func (self *reader) readUint64() uint64 {
-	i := binary.LittleEndian.Uint64(self.data)
-	self.data = self.data[8:]
-	return i
+	self.read(self.buf[:8])
+	return binary.LittleEndian.Uint64(self.buf[:8])
 }
 
 func (self *reader) readLuaInteger() int64 {
@@ -51,39 +84,48 @@ func (self *reader) readString() string {
 	return string(bytes) // todo
 }
 
+// reads a count and how many of the items can be preallocated
+func (self *reader) readCount() (int, int) {
+	n := int(self.readUint32())
+	if n > maxPrealloc {
+		return n, maxPrealloc
+	}
+	return n, n
+}
+
 func (self *reader) checkHeader() {
 	if string(self.readBytes(4)) != LUA_SIGNATURE {
-		panic("not a precompiled chunk!")
+		self.error("not a")
 	}
 	if self.readByte() != LUAC_VERSION {
-		panic("version mismatch!")
+		self.error("version mismatch in")
 	}
 	if self.readByte() != LUAC_FORMAT {
-		panic("format mismatch!")
+		self.error("format mismatch in")
 	}
 	if string(self.readBytes(6)) != LUAC_DATA {
-		panic("corrupted!")
+		self.error("corrupted")
 	}
 	if self.readByte() != CINT_SIZE {
-		panic("int size mismatch!")
+		self.error("int size mismatch in")
 	}
 	if self.readByte() != CSIZET_SIZE {
-		panic("size_t size mismatch!")
+		self.error("size_t size mismatch in")
 	}
 	if self.readByte() != INSTRUCTION_SIZE {
-		panic("instruction size mismatch!")
+		self.error("Instruction size mismatch in")
 	}
 	if self.readByte() != LUA_INTEGER_SIZE {
-		panic("lua_Integer size mismatch!")
+		self.error("lua_Integer size mismatch in")
 	}
 	if self.readByte() != LUA_NUMBER_SIZE {
-		panic("lua_Number size mismatch!")
+		self.error("lua_Number size mismatch in")
 	}
 	if self.readLuaInteger() != LUAC_INT {
-		panic("endianness mismatch!")
+		self.error("endianness mismatch in")
 	}
 	if self.readLuaNumber() != LUAC_NUM {
-		panic("float format mismatch!")
+		self.error("float format mismatch in")
 	}
 }
 
@@ -110,17 +152,19 @@ func (self *reader) readProto(parentSource string) *Prototype {
 }
 
 func (self *reader) readCode() []uint32 {
-	code := make([]uint32, self.readUint32())
-	for i := range code {
-		code[i] = self.readUint32()
+	n, c := self.readCount()
+	code := make([]uint32, 0, c)
+	for i := 0; i < n; i++ {
+		code = append(code, self.readUint32())
 	}
 	return code
 }
 
 func (self *reader) readConstants() []interface{} {
-	constants := make([]interface{}, self.readUint32())
-	for i := range constants {
-		constants[i] = self.readConstant()
+	n, c := self.readCount()
+	constants := make([]interface{}, 0, c)
+	for i := 0; i < n; i++ {
+		constants = append(constants, self.readConstant())
 	}
 	return constants
 }
@@ -138,53 +182,59 @@ func (self *reader) readConstant() interface{} {
 	case TAG_SHORT_STR, TAG_LONG_STR:
 		return self.readString()
 	default:
-		panic("corrupted!") // todo
+		self.error("corrupted")
+		return nil
 	}
 }
 
 func (self *reader) readUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, self.readUint32())
-	for i := range upvalues {
-		upvalues[i] = Upvalue{
+	n, c := self.readCount()
+	upvalues := make([]Upvalue, 0, c)
+	for i := 0; i < n; i++ {
+		upvalues = append(upvalues, Upvalue{
 			Instack: self.readByte(),
 			Idx:     self.readByte(),
-		}
+		})
 	}
 	return upvalues
 }
 
 func (self *reader) readProtos(parentSource string) []*Prototype {
-	protos := make([]*Prototype, self.readUint32())
-	for i := range protos {
-		protos[i] = self.readProto(parentSource)
+	n, c := self.readCount()
+	protos := make([]*Prototype, 0, c)
+	for i := 0; i < n; i++ {
+		protos = append(protos, self.readProto(parentSource))
 	}
 	return protos
 }
 
 func (self *reader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, self.readUint32())
-	for i := range lineInfo {
-		lineInfo[i] = self.readUint32()
+	n, c := self.readCount()
+	lineInfo := make([]uint32, 0, c)
+	for i := 0; i < n; i++ {
+		lineInfo = append(lineInfo, self.readUint32())
 	}
 	return lineInfo
 }
 
 func (self *reader) readLocVars() []LocVar {
-	locVars := make([]LocVar, self.readUint32())
-	for i := range locVars {
-		locVars[i] = LocVar{
+	n, c := self.readCount()
+	locVars := make([]LocVar, 0, c)
+	for i := 0; i < n; i++ {
+		locVars = append(locVars, LocVar{
 			VarName: self.readString(),
 			StartPC: self.readUint32(),
 			EndPC:   self.readUint32(),
-		}
+		})
 	}
 	return locVars
 }
 
 func (self *reader) readUpvalueNames() []string {
-	names := make([]string, self.readUint32())
-	for i := range names {
-		names[i] = self.readString()
+	n, c := self.readCount()
+	names := make([]string, 0, c)
+	for i := 0; i < n; i++ {
+		names = append(names, self.readString())
 	}
 	return names
 }
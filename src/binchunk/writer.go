@@ -0,0 +1,176 @@
+package binchunk
+
+import "bytes"
+import "encoding/binary"
+import "math"
+
+// strings up to this length are dumped as short strings
+const LUAI_MAXSHORTLEN = 40
+
+// Dump serializes proto as a main function in the format written by
+// luac 5.3. If strip is true the debug information is left out.
+func Dump(proto *Prototype, strip bool) []byte {
+	w := &writer{strip: strip}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues))) // size_upvalues
+	w.writeProto(proto, "")
+	return w.buf.Bytes()
+}
+
+// lua-5.3.4/src/ldump.c
+type writer struct {
+	buf   bytes.Buffer
+	strip bool
+}
+
+func (self *writer) writeByte(b byte) {
+	self.buf.WriteByte(b)
+}
+
+func (self *writer) writeUint32(i uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], i)
+	self.buf.Write(b[:])
+}
+
+func (self *writer) writeUint64(i uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], i)
+	self.buf.Write(b[:])
+}
+
+func (self *writer) writeLuaInteger(i int64) {
+	self.writeUint64(uint64(i))
+}
+
+func (self *writer) writeLuaNumber(f float64) {
+	self.writeUint64(math.Float64bits(f))
+}
+
+func (self *writer) writeNilString() {
+	self.writeByte(0)
+}
+
+func (self *writer) writeString(s string) {
+	size := uint64(len(s)) + 1 /* include trailing '\0' */
+	if size < 0xFF {
+		self.writeByte(byte(size))
+	} else {
+		self.writeByte(0xFF)
+		self.writeUint64(size) // size_t
+	}
+	self.buf.WriteString(s)
+}
+
+func (self *writer) writeHeader() {
+	self.buf.WriteString(LUA_SIGNATURE)
+	self.writeByte(LUAC_VERSION)
+	self.writeByte(LUAC_FORMAT)
+	self.buf.WriteString(LUAC_DATA)
+	self.writeByte(CINT_SIZE)
+	self.writeByte(CSIZET_SIZE)
+	self.writeByte(INSTRUCTION_SIZE)
+	self.writeByte(LUA_INTEGER_SIZE)
+	self.writeByte(LUA_NUMBER_SIZE)
+	self.writeLuaInteger(LUAC_INT)
+	self.writeLuaNumber(LUAC_NUM)
+}
+
+func (self *writer) writeProto(proto *Prototype, parentSource string) {
+	if self.strip || proto.Source == parentSource {
+		self.writeNilString() /* same source as its parent */
+	} else {
+		self.writeString(proto.Source)
+	}
+	self.writeUint32(proto.LineDefined)
+	self.writeUint32(proto.LastLineDefined)
+	self.writeByte(proto.NumParams)
+	self.writeByte(proto.IsVararg)
+	self.writeByte(proto.MaxStackSize)
+	self.writeCode(proto.Code)
+	self.writeConstants(proto.Constants)
+	self.writeUpvalues(proto.Upvalues)
+	self.writeProtos(proto.Protos, proto.Source)
+	self.writeDebug(proto)
+}
+
+func (self *writer) writeCode(code []uint32) {
+	self.writeUint32(uint32(len(code)))
+	for _, inst := range code {
+		self.writeUint32(inst)
+	}
+}
+
+func (self *writer) writeConstants(constants []interface{}) {
+	self.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		self.writeConstant(k)
+	}
+}
+
+func (self *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		self.writeByte(TAG_NIL)
+	case bool:
+		self.writeByte(TAG_BOOLEAN)
+		if x {
+			self.writeByte(1)
+		} else {
+			self.writeByte(0)
+		}
+	case int64:
+		self.writeByte(TAG_INTEGER)
+		self.writeLuaInteger(x)
+	case float64:
+		self.writeByte(TAG_NUMBER)
+		self.writeLuaNumber(x)
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			self.writeByte(TAG_SHORT_STR)
+		} else {
+			self.writeByte(TAG_LONG_STR)
+		}
+		self.writeString(x)
+	default:
+		panic("unexpected constant!")
+	}
+}
+
+func (self *writer) writeUpvalues(upvalues []Upvalue) {
+	self.writeUint32(uint32(len(upvalues)))
+	for _, uv := range upvalues {
+		self.writeByte(uv.Instack)
+		self.writeByte(uv.Idx)
+	}
+}
+
+func (self *writer) writeProtos(protos []*Prototype, source string) {
+	self.writeUint32(uint32(len(protos)))
+	for _, p := range protos {
+		self.writeProto(p, source)
+	}
+}
+
+func (self *writer) writeDebug(proto *Prototype) {
+	if self.strip {
+		self.writeUint32(0) /* line info */
+		self.writeUint32(0) /* local variables */
+		self.writeUint32(0) /* upvalue names */
+		return
+	}
+	self.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		self.writeUint32(line)
+	}
+	self.writeUint32(uint32(len(proto.LocVars)))
+	for _, locVar := range proto.LocVars {
+		self.writeString(locVar.VarName)
+		self.writeUint32(locVar.StartPC)
+		self.writeUint32(locVar.EndPC)
+	}
+	self.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		self.writeString(name)
+	}
+}
@@ -0,0 +1,161 @@
+package binchunk_test
+
+import "bytes"
+import "reflect"
+import "strings"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+import . "github.com/tdkr/go-luavm/src/binchunk"
+import "github.com/tdkr/go-luavm/src/compiler"
+import "github.com/tdkr/go-luavm/src/state"
+
+/* nested functions, upvalues, locals and every kind of constant */
+const source = `
+local long = "` + "a long string constant, longer than forty bytes" + `"
+local n, f, b = 42, 0.5, true
+local function counter(start)
+  local count = start
+  return function(step)
+    count = count + (step or 1)
+    return count, long, n, f, b, nil == count, ""
+  end
+end
+local c = counter(-9007199254740993)
+c()
+return c(2)
+`
+
+const results = "-9007199254740990 " +
+	"a long string constant, longer than forty bytes 42 0.5 true false "
+
+func compile(t *testing.T, chunk string) *Prototype {
+	proto, err := compiler.Compile(chunk, "@test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proto
+}
+
+// runs a binary or text chunk, returns its results converted to strings
+func run(t *testing.T, chunk []byte) string {
+	ls := state.New()
+	ls.OpenLibs()
+	if status := ls.Load(chunk, "=test", "bt"); status != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, LUA_MULTRET)
+	var out []string
+	for i, n := 1, ls.GetTop(); i <= n; i++ {
+		out = append(out, ls.ToString2(i))
+		ls.Pop(1)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	proto := compile(t, source)
+	for _, strip := range []bool{false, true} {
+		data := Dump(proto, strip)
+		undumped := Undump(data)
+		if again := Dump(undumped, strip); !bytes.Equal(again, data) {
+			t.Errorf("strip=%v: dump of undumped proto differs", strip)
+		}
+		if got := run(t, data); got != results {
+			t.Errorf("strip=%v: got %q, want %q", strip, got, results)
+		}
+
+		if !reflect.DeepEqual(undumped.Constants, proto.Constants) ||
+			!reflect.DeepEqual(undumped.Code, proto.Code) ||
+			len(undumped.Protos) != 1 || len(undumped.Protos[0].Protos) != 1 {
+			t.Errorf("strip=%v: constants, code or nested protos differ", strip)
+		}
+		inner := undumped.Protos[0].Protos[0]
+		if !reflect.DeepEqual(inner.Upvalues, proto.Protos[0].Protos[0].Upvalues) {
+			t.Errorf("strip=%v: upvalues differ", strip)
+		}
+
+		if strip {
+			if undumped.Source != "=?" || len(inner.LineInfo) != 0 ||
+				len(inner.LocVars) != 0 || len(inner.UpvalueNames) != 0 {
+				t.Errorf("debug information was not stripped: %+v", inner)
+			}
+		} else {
+			if undumped.Source != "@test.lua" || inner.Source != "@test.lua" {
+				t.Errorf("got sources %q and %q", undumped.Source, inner.Source)
+			}
+			want := proto.Protos[0].Protos[0]
+			if !reflect.DeepEqual(inner.LineInfo, want.LineInfo) ||
+				!reflect.DeepEqual(inner.LocVars, want.LocVars) ||
+				!reflect.DeepEqual(inner.UpvalueNames, want.UpvalueNames) ||
+				inner.LineDefined != want.LineDefined ||
+				inner.LastLineDefined != want.LastLineDefined {
+				t.Errorf("debug information differs: %+v", inner)
+			}
+		}
+	}
+	if got := run(t, []byte(source)); got != results {
+		t.Errorf("source: got %q, want %q", got, results)
+	}
+}
+
+func TestDumpConstants(t *testing.T) {
+	proto := &Prototype{
+		Source:       "=constants",
+		MaxStackSize: 2,
+		Code:         []uint32{0x00800026}, /* RETURN 0 1 */
+		Constants: []interface{}{nil, true, false, int64(0), int64(-1),
+			int64(1) << 62, 0.0, -2.5, 1e300, "", "short",
+			strings.Repeat("x", 40), strings.Repeat("y", 41), strings.Repeat("z", 300)},
+		Upvalues:     []Upvalue{{Instack: 1, Idx: 0}},
+		LineInfo:     []uint32{1},
+		LocVars:      []LocVar{{VarName: "v", StartPC: 0, EndPC: 1}},
+		UpvalueNames: []string{"_ENV"},
+		Protos:       []*Prototype{},
+	}
+	got := Undump(Dump(proto, false))
+	if !reflect.DeepEqual(got, proto) {
+		t.Errorf("got  %+v\nwant %+v", got, proto)
+	}
+}
+
+func TestDumpHeader(t *testing.T) {
+	data := Dump(compile(t, "return 1"), true)
+	header := "\x1bLua\x53\x00\x19\x93\r\n\x1a\n\x04\x08\x04\x08\x08" +
+		"\x78\x56\x00\x00\x00\x00\x00\x00" + /* LUAC_INT */
+		"\x00\x00\x00\x00\x00\x28\x77\x40" /* LUAC_NUM */
+	if !bytes.HasPrefix(data, []byte(header)) {
+		t.Errorf("got header %q", data[:len(header)])
+	}
+	if !IsBinaryChunk(data) {
+		t.Error("not a binary chunk")
+	}
+}
+
+func TestStringDump(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	err := ls.DoStringE(`
+		local prefix, suffix = "<", ">"
+		local function wrap(x) return tostring(prefix) .. x .. tostring(suffix) end
+		assert(wrap("a") == "<a>")
+
+		for _, strip in ipairs({false, true}) do
+			local g = assert(load(string.dump(wrap, strip), "wrap", "b"))
+			-- the first upvalue is _ENV, the others start as nil
+			assert(g("a") == "nila" .. "nil", g("a"))
+			local name, value = debug.getupvalue(g, 1)
+			assert(value == _G)
+			if not strip then
+				assert(name == "_ENV" and debug.getupvalue(g, 2) == "prefix")
+			end
+			debug.setupvalue(g, 2, "[")
+			debug.setupvalue(g, 3, "]")
+			assert(g("a") == "[a]")
+		end
+
+		assert(not pcall(string.dump, print))
+		assert(select(2, load(string.dump(wrap), "x", "t")):find("binary chunk"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,447 @@
+// Command lua is a standalone interpreter, a port of lua.c:
+//
+//	usage: lua [options] [script [args]]
+//
+// Uncaught errors are reported with a stack traceback.
+package main
+
+import "bufio"
+import "fmt"
+import "os"
+import "strings"
+import . "github.com/tdkr/go-luavm/src/api"
+import "github.com/tdkr/go-luavm/src/state"
+
+const progName = "lua"
+
+const (
+	LUA_PROMPT  = "> "
+	LUA_PROMPT2 = ">> "
+)
+
+/* bits of various argument indicators in 'args' */
+const (
+	has_error = 1  /* bad option */
+	has_i     = 2  /* -i */
+	has_v     = 4  /* -v */
+	has_e     = 8  /* -e */
+	has_E     = 16 /* -E */
+)
+
+func main() {
+	ls := state.New()
+	ls.PushGoFunction(pmain)
+	status := ls.PCall(0, 1, 0)
+	if status != LUA_OK || !ls.ToBoolean(-1) {
+		report(ls, status)
+		os.Exit(1)
+	}
+}
+
+// Prints an error message, adding the program name in front of it
+// (if present)
+// lua-5.3.4/src/lua.c#l_message()
+func lMessage(pname, msg string) {
+	if pname != "" {
+		fmt.Fprintf(os.Stderr, "%s: ", pname)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", msg)
+}
+
+// Check whether 'status' is not OK and, if so, prints the error
+// message on the top of the stack. It assumes that the error object
+// is a string, as it was either generated by Lua or by 'msghandler'.
+// lua-5.3.4/src/lua.c#report()
+func report(ls LuaState, status int) int {
+	if status != LUA_OK {
+		lMessage(progName, ls.ToString(-1))
+		ls.Pop(1) /* remove message */
+	}
+	return status
+}
+
+// Message handler used to run all chunks
+// lua-5.3.4/src/lua.c#msghandler()
+func msgHandler(ls LuaState) int {
+	msg, ok := "", ls.Type(1) == LUA_TSTRING || ls.Type(1) == LUA_TNUMBER
+	if ok {
+		msg = ls.ToString(1)
+	} else { /* is error object not a string? */
+		if ls.CallMeta(1, "__tostring") && /* does it have a metamethod */
+			ls.Type(-1) == LUA_TSTRING { /* that produces a string? */
+			return 1 /* that is the message */
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(1))
+	}
+	ls.PushString(ls.Traceback(ls, msg, 1)) /* append a standard traceback */
+	return 1                                /* return the traceback */
+}
+
+// Interface to 'lua_pcall', which sets appropriate message function.
+// lua-5.3.4/src/lua.c#docall()
+func doCall(ls LuaState, narg, nres int) int {
+	base := ls.GetTop() - narg    /* function index */
+	ls.PushGoFunction(msgHandler) /* push message handler */
+	ls.Insert(base)               /* put it under function and args */
+	status := ls.PCall(narg, nres, base)
+	ls.Remove(base) /* remove message handler from the stack */
+	return status
+}
+
+// lua-5.3.4/src/lua.c#print_version()
+func printVersion() {
+	fmt.Println("Lua 5.3 (go-luavm)")
+}
+
+// Create the 'arg' table, which stores all arguments from the
+// command line ('argv'). It should be aligned so that, at index 0,
+// it has 'argv[script]', which is the script name. The arguments
+// to the script (everything after 'script') go to positive indices;
+// other arguments (before the script name) go to negative indices.
+// If there is no script name, assume interpreter's name as base.
+// lua-5.3.4/src/lua.c#createargtable()
+func createArgTable(ls LuaState, argv []string, script int) {
+	if script == len(argv) { /* no script name? */
+		script = 0 /* make 'script' point to 'argv[0]' */
+	}
+	narg := len(argv) - (script + 1) /* number of positive indices */
+	ls.CreateTable(narg, script+1)
+	for i, arg := range argv {
+		ls.PushString(arg)
+		ls.RawSetI(-2, int64(i-script))
+	}
+	ls.SetGlobal("arg")
+}
+
+// lua-5.3.4/src/lua.c#dochunk()
+func doChunk(ls LuaState, status int) int {
+	if status == LUA_OK {
+		status = doCall(ls, 0, 0)
+	}
+	return report(ls, status)
+}
+
+// lua-5.3.4/src/lua.c#dofile()
+func doFile(ls LuaState, name string) int {
+	return doChunk(ls, ls.LoadFile(name))
+}
+
+// lua-5.3.4/src/lua.c#dostring()
+func doString(ls LuaState, s, name string) int {
+	return doChunk(ls, ls.Load([]byte(s), name, "bt"))
+}
+
+// Calls 'require(name)' and stores the result in a global variable
+// with the given name.
+// lua-5.3.4/src/lua.c#dolibrary()
+func doLibrary(ls LuaState, name string) int {
+	ls.GetGlobal("require")
+	ls.PushString(name)
+	status := doCall(ls, 1, 1) /* call 'require(name)' */
+	if status == LUA_OK {
+		ls.SetGlobal(name) /* global[name] = require return */
+	}
+	return report(ls, status)
+}
+
+// Push on the stack the contents of table 'arg' from 1 to #arg
+// lua-5.3.4/src/lua.c#pushargs()
+func pushArgs(ls LuaState) int {
+	if ls.GetGlobal("arg") != LUA_TTABLE {
+		ls.Error2("'arg' is not a table")
+	}
+	n := int(ls.Len2(-1))
+	ls.CheckStack2(n+3, "too many arguments to script")
+	for i := 1; i <= n; i++ {
+		ls.RawGetI(-i, int64(i))
+	}
+	ls.Remove(-n - 1) /* remove table from the stack */
+	return n
+}
+
+// lua-5.3.4/src/lua.c#handle_script()
+func handleScript(ls LuaState, argv []string, script int) int {
+	fname := argv[script]
+	if fname == "-" && argv[script-1] != "--" {
+		fname = "" /* stdin */
+	}
+	status := ls.LoadFile(fname)
+	if status == LUA_OK {
+		n := pushArgs(ls) /* push arguments to script */
+		status = doCall(ls, n, LUA_MULTRET)
+	}
+	return report(ls, status)
+}
+
+// Traverses all arguments from 'argv', returning a mask with those
+// needed before running any Lua code (or an error code if it finds
+// any invalid argument). 'first' returns the first not-handled argument
+// (either the script name or a bad argument in case of error).
+// lua-5.3.4/src/lua.c#collectargs()
+func collectArgs(argv []string) (args, first int) {
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if !strings.HasPrefix(arg, "-") { /* not an option? */
+			return args, i /* stop handling options */
+		}
+		switch arg {
+		case "-": /* script "name" is '-' */
+			return args, i
+		case "--": /* '--' ends the options */
+			return args, i + 1
+		case "-i":
+			args |= has_i | has_v /* (-i implies -v) */
+		case "-E":
+			args |= has_E
+		case "-v":
+			args |= has_v
+		case "-e", "-l":
+			if arg == "-e" {
+				args |= has_e
+			}
+			if i+1 >= len(argv) || strings.HasPrefix(argv[i+1], "-") {
+				return has_error, i /* no next argument or it is another option */
+			}
+			i++ /* skip next argument */
+		default:
+			if strings.HasPrefix(arg, "-e") || strings.HasPrefix(arg, "-l") {
+				if arg[1] == 'e' {
+					args |= has_e
+				}
+				continue /* argument glued to the option */
+			}
+			return has_error, i /* invalid option */
+		}
+	}
+	return args, len(argv) /* no script name */
+}
+
+// Processes options 'e' and 'l', which involve running Lua code.
+// Returns false if some code raises an error.
+// lua-5.3.4/src/lua.c#runargs()
+func runArgs(ls LuaState, argv []string, n int) bool {
+	for i := 1; i < n; i++ {
+		option := argv[i][1]
+		if option != 'e' && option != 'l' {
+			continue
+		}
+		extra := argv[i][2:] /* both options need an argument */
+		if extra == "" {
+			i++
+			extra = argv[i]
+		}
+		var status int
+		if option == 'e' {
+			status = doString(ls, extra, "=(command line)")
+		} else {
+			status = doLibrary(ls, extra)
+		}
+		if status != LUA_OK {
+			return false
+		}
+	}
+	return true
+}
+
+// lua-5.3.4/src/lua.c#handle_luainit()
+func handleLuaInit(ls LuaState) int {
+	name := "=LUA_INIT_5_3"
+	init, ok := os.LookupEnv(name[1:])
+	if !ok {
+		name = "=LUA_INIT"
+		init, ok = os.LookupEnv(name[1:]) /* try alternative name */
+	}
+	if !ok {
+		return LUA_OK
+	} else if strings.HasPrefix(init, "@") {
+		return doFile(ls, init[1:])
+	} else {
+		return doString(ls, init, name)
+	}
+}
+
+// lua-5.3.4/src/lua.c#print_usage()
+func printUsage(badOption string) {
+	if len(badOption) > 1 && (badOption[1] == 'e' || badOption[1] == 'l') {
+		fmt.Fprintf(os.Stderr, "%s: '%s' needs argument\n", progName, badOption)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: unrecognized option '%s'\n", progName, badOption)
+	}
+	fmt.Fprintf(os.Stderr,
+		"usage: %s [options] [script [args]]\n"+
+			"Available options are:\n"+
+			"  -e stat  execute string 'stat'\n"+
+			"  -i       enter interactive mode after executing 'script'\n"+
+			"  -l name  require library 'name'\n"+
+			"  -v       show version information\n"+
+			"  -E       ignore environment variables\n"+
+			"  --       stop handling options\n"+
+			"  -        stop handling options and execute stdin\n",
+		progName)
+}
+
+// Main body of stand-alone interpreter (to be called in protected mode).
+// Reads the options and handles them all.
+// lua-5.3.4/src/lua.c#pmain()
+func pmain(ls LuaState) int {
+	argv := os.Args
+	args, script := collectArgs(argv)
+	if args == has_error { /* bad arg? */
+		printUsage(argv[script]) /* 'script' has index of bad arg. */
+		return 0
+	}
+	if args&has_v != 0 { /* option '-v'? */
+		printVersion()
+	}
+	ls.OpenLibs()                    /* open standard libraries */
+	createArgTable(ls, argv, script) /* create table 'arg' */
+	if args&has_E == 0 {             /* no option '-E'? */
+		if handleLuaInit(ls) != LUA_OK { /* run LUA_INIT */
+			return 0 /* error running LUA_INIT */
+		}
+	}
+	if !runArgs(ls, argv, script) { /* execute arguments -e and -l */
+		return 0 /* something failed */
+	}
+	if script < len(argv) && /* execute main script (if there is one) */
+		handleScript(ls, argv, script) != LUA_OK {
+		return 0
+	}
+	if args&has_i != 0 { /* -i option? */
+		doREPL(ls) /* do read-eval-print loop */
+	} else if script == len(argv) && args&(has_e|has_v) == 0 { /* no arguments? */
+		if stdinIsTTY() { /* running in interactive mode? */
+			printVersion()
+			doREPL(ls) /* do read-eval-print loop */
+		} else {
+			doFile(ls, "") /* executes stdin as a file */
+		}
+	}
+	ls.PushBoolean(true) /* signal no errors */
+	return 1
+}
+
+// lua-5.3.4/src/lua.c#lua_stdin_is_tty()
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+/* the interactive input, shared by all the lines of the REPL */
+var stdin = bufio.NewReader(os.Stdin)
+
+// Prompt the user, read a line, and push it into the Lua stack.
+// lua-5.3.4/src/lua.c#pushline()
+func pushLine(ls LuaState, firstLine bool) bool {
+	if firstLine {
+		fmt.Print(LUA_PROMPT)
+	} else {
+		fmt.Print(LUA_PROMPT2)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return false /* no input (prompt will be popped by caller) */
+	}
+	line = strings.TrimSuffix(line, "\n")          /* remove newline */
+	if firstLine && strings.HasPrefix(line, "=") { /* for compatibility with 5.2, ... */
+		line = "return " + line[1:] /* change '=' to 'return' */
+	}
+	ls.PushString(line)
+	return true
+}
+
+// Try to compile line on the stack as 'return <line>;'; on return, stack
+// has either compiled chunk or original line (if compilation failed).
+// lua-5.3.4/src/lua.c#addreturn()
+func addReturn(ls LuaState) int {
+	line := ls.ToString(-1) /* original line */
+	retLine := "return " + line + ";"
+	status := ls.Load([]byte(retLine), "=stdin", "bt")
+	if status != LUA_OK {
+		ls.Pop(1) /* remove result from 'luaL_loadbuffer' */
+	}
+	return status
+}
+
+// Check whether 'status' signals a syntax error and the error
+// message at the top of the stack ends with the above mark for
+// incomplete statements.
+// lua-5.3.4/src/lua.c#incomplete()
+func incomplete(ls LuaState, status int) bool {
+	if status == LUA_ERRSYNTAX {
+		if strings.HasSuffix(ls.ToString(-1), "<eof>") {
+			ls.Pop(1)
+			return true
+		}
+	}
+	return false /* else... */
+}
+
+// Read multiple lines until a complete Lua statement
+// lua-5.3.4/src/lua.c#multiline()
+func multiLine(ls LuaState) int {
+	for { /* repeat until gets a complete statement */
+		line := ls.ToString(1)
+		status := ls.Load([]byte(line), "=stdin", "bt") /* try it */
+		if !incomplete(ls, status) || !pushLine(ls, false) {
+			return status /* cannot or should not try to add continuation line */
+		}
+		ls.PushString("\n") /* add newline... */
+		ls.Insert(-2)       /* ...between the two lines */
+		ls.Concat(3)        /* join them */
+	}
+}
+
+// Read a line and try to load (compile) it first as an expression (by
+// adding "return " in front of it) and second as a statement. Return
+// the final status of load/call with the resulting function (if any)
+// in the top of the stack.
+// lua-5.3.4/src/lua.c#loadline()
+func loadLine(ls LuaState) (int, bool) {
+	ls.SetTop(0)
+	if !pushLine(ls, true) {
+		return 0, false /* no input */
+	}
+	status := addReturn(ls)
+	if status != LUA_OK { /* 'return ...' did not work? */
+		status = multiLine(ls) /* try as command, maybe with continuation lines */
+	}
+	ls.Remove(1) /* remove line from the stack */
+	return status, true
+}
+
+// Prints (calling the Lua 'print' function) any values on the stack
+// lua-5.3.4/src/lua.c#l_print()
+func lPrint(ls LuaState) {
+	n := ls.GetTop()
+	if n > 0 { /* any result to be printed? */
+		ls.CheckStack2(LUA_MINSTACK, "too many results to print")
+		ls.GetGlobal("print")
+		ls.Insert(1)
+		if ls.PCall(n, 0, 0) != LUA_OK {
+			lMessage(progName, fmt.Sprintf("error calling 'print' (%s)", ls.ToString(-1)))
+		}
+	}
+}
+
+// Do the REPL: repeatedly read (load) a line, evaluate (call) it, and
+// print any results.
+// lua-5.3.4/src/lua.c#doREPL()
+func doREPL(ls LuaState) {
+	for {
+		status, ok := loadLine(ls)
+		if !ok {
+			break
+		}
+		if status == LUA_OK {
+			status = doCall(ls, 0, LUA_MULTRET)
+		}
+		if status == LUA_OK {
+			lPrint(ls)
+		} else {
+			report(ls, status)
+		}
+	}
+	ls.SetTop(0) /* clear stack */
+	fmt.Println()
+}
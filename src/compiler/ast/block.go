@@ -7,6 +7,7 @@ package ast
 // retstat ::= return [explist] [‘;’]
 // explist ::= exp {‘,’ exp}
 type Block struct {
+	Span
 	LastLine int
 	Stats    []Stat
 	RetExps  []Exp
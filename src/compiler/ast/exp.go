@@ -11,31 +11,56 @@ var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
 functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
 */
 
-type Exp interface{}
+type Exp interface {
+	Node
+}
+
+// nil
+type NilExp struct {
+	Span
+	Line int
+}
+
+// true
+type TrueExp struct {
+	Span
+	Line int
+}
+
+// false
+type FalseExp struct {
+	Span
+	Line int
+}
 
-type NilExp struct{ Line int }    // nil
-type TrueExp struct{ Line int }   // true
-type FalseExp struct{ Line int }  // false
-type VarargExp struct{ Line int } // ...
+// ...
+type VarargExp struct {
+	Span
+	Line int
+}
 
 // Numeral
 type IntegerExp struct {
+	Span
 	Line int
 	Val  int64
 }
 type FloatExp struct {
+	Span
 	Line int
 	Val  float64
 }
 
 // LiteralString
 type StringExp struct {
+	Span
 	Line int
 	Str  string
 }
 
 // unop exp
 type UnopExp struct {
+	Span
 	Line int // line of operator
 	Op   int // operator
 	Exp  Exp
@@ -43,6 +68,7 @@ type UnopExp struct {
 
 // exp1 op exp2
 type BinopExp struct {
+	Span
 	Line int // line of operator
 	Op   int // operator
 	Exp1 Exp
@@ -50,6 +76,7 @@ type BinopExp struct {
 }
 
 type ConcatExp struct {
+	Span
 	Line int // line of last ..
 	Exps []Exp
 }
@@ -59,6 +86,7 @@ type ConcatExp struct {
 // field ::= ‘[’ exp ‘]’ ‘=’ exp | Name ‘=’ exp | exp
 // fieldsep ::= ‘,’ | ‘;’
 type TableConstructorExp struct {
+	Span
 	Line     int // line of `{` ?
 	LastLine int // line of `}`
 	KeyExps  []Exp
@@ -70,6 +98,7 @@ type TableConstructorExp struct {
 // parlist ::= namelist [‘,’ ‘...’] | ‘...’
 // namelist ::= Name {‘,’ Name}
 type FuncDefExp struct {
+	Span
 	Line     int
 	LastLine int // line of `end`
 	ParList  []string
@@ -87,21 +116,25 @@ prefixexp ::= Name |
 */
 
 type NameExp struct {
+	Span
 	Line int
 	Name string
 }
 
 type ParensExp struct {
+	Span
 	Exp Exp
 }
 
 type TableAccessExp struct {
+	Span
 	LastLine  int // line of `]` ?
 	PrefixExp Exp
 	KeyExp    Exp
 }
 
 type FuncCallExp struct {
+	Span
 	Line      int // line of `(` ?
 	LastLine  int // line of ')'
 	PrefixExp Exp
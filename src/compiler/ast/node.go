@@ -0,0 +1,25 @@
+package ast
+
+import "github.com/tdkr/go-luavm/src/compiler/lexer"
+
+// Node is implemented by all expressions, statements and blocks.
+type Node interface {
+	Pos() lexer.Position // position of the first byte of the node
+	End() lexer.Position // position just past the last byte of the node
+}
+
+// Span is embedded in every node to record the source range it covers.
+// Nodes made up by the parser (like the ‘true’ of an ‘else’ branch or
+// the step of a numeric ‘for’) get an empty span where they would appear.
+type Span struct {
+	StartPos lexer.Position
+	EndPos   lexer.Position
+}
+
+func (self Span) Pos() lexer.Position {
+	return self.StartPos
+}
+
+func (self Span) End() lexer.Position {
+	return self.EndPos
+}
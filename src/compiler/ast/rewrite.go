@@ -0,0 +1,162 @@
+package ast
+
+import "fmt"
+
+// Rewrite traverses an AST in depth-first order and replaces every node
+// with the result of f. The children of a node are rewritten before the
+// node itself, so f always sees subtrees that have already been rewritten.
+// Returning the node unchanged keeps it. Only statements may be removed,
+// by returning nil, which drops them from their block; expressions must
+// be replaced with expressions, statements with statements, and blocks,
+// function bodies and method names with nodes of the same type, or
+// Rewrite panics. Rewrite returns the result of f(node).
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Block:
+		n.Stats = rewriteStatList(n.Stats, f)
+		rewriteExpList(n.RetExps, f)
+
+	/* statements */
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+		// nothing to do
+	case *DoStat:
+		n.Block = rewriteBlock(n.Block, f)
+	case *WhileStat:
+		n.Exp = rewriteExp(n.Exp, f)
+		n.Block = rewriteBlock(n.Block, f)
+	case *RepeatStat:
+		n.Block = rewriteBlock(n.Block, f)
+		n.Exp = rewriteExp(n.Exp, f)
+	case *IfStat:
+		for i, exp := range n.Exps {
+			n.Exps[i] = rewriteExp(exp, f)
+			n.Blocks[i] = rewriteBlock(n.Blocks[i], f)
+		}
+	case *ForNumStat:
+		n.InitExp = rewriteExp(n.InitExp, f)
+		n.LimitExp = rewriteExp(n.LimitExp, f)
+		n.StepExp = rewriteExp(n.StepExp, f)
+		n.Block = rewriteBlock(n.Block, f)
+	case *ForInStat:
+		rewriteExpList(n.ExpList, f)
+		n.Block = rewriteBlock(n.Block, f)
+	case *AssignStat:
+		rewriteExpList(n.VarList, f)
+		rewriteExpList(n.ExpList, f)
+	case *LocalVarDeclStat:
+		rewriteExpList(n.ExpList, f)
+	case *LocalFuncDefStat:
+		n.Exp = rewriteFuncDefExp(n.Exp, f)
+
+	/* expressions */
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp,
+		*IntegerExp, *FloatExp, *StringExp, *NameExp:
+		// nothing to do
+	case *UnopExp:
+		n.Exp = rewriteExp(n.Exp, f)
+	case *BinopExp:
+		n.Exp1 = rewriteExp(n.Exp1, f)
+		n.Exp2 = rewriteExp(n.Exp2, f)
+	case *ConcatExp:
+		rewriteExpList(n.Exps, f)
+	case *TableConstructorExp:
+		for i, valExp := range n.ValExps {
+			if n.KeyExps[i] != nil {
+				n.KeyExps[i] = rewriteExp(n.KeyExps[i], f)
+			}
+			n.ValExps[i] = rewriteExp(valExp, f)
+		}
+	case *FuncDefExp:
+		n.Block = rewriteBlock(n.Block, f)
+	case *ParensExp:
+		n.Exp = rewriteExp(n.Exp, f)
+	case *TableAccessExp:
+		n.PrefixExp = rewriteExp(n.PrefixExp, f)
+		n.KeyExp = rewriteExp(n.KeyExp, f)
+	case *FuncCallExp:
+		n.PrefixExp = rewriteExp(n.PrefixExp, f)
+		if n.NameExp != nil {
+			n.NameExp = rewriteNameExp(n.NameExp, f)
+		}
+		rewriteExpList(n.Args, f)
+
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+func rewriteStatList(list []Stat, f func(Node) Node) []Stat {
+	stats := list[:0]
+	for _, stat := range list {
+		node := Rewrite(stat, f)
+		if node == nil {
+			continue // removed
+		}
+		if !isStat(node) {
+			panic(fmt.Sprintf("ast.Rewrite: %T cannot replace a statement", node))
+		}
+		stats = append(stats, node)
+	}
+	return stats
+}
+
+func rewriteExpList(list []Exp, f func(Node) Node) {
+	for i, exp := range list {
+		list[i] = rewriteExp(exp, f)
+	}
+}
+
+func rewriteExp(exp Exp, f func(Node) Node) Exp {
+	node := Rewrite(exp, f)
+	if isExp(node) {
+		return node
+	}
+	panic(fmt.Sprintf("ast.Rewrite: %T cannot replace an expression", node))
+}
+
+func rewriteBlock(block *Block, f func(Node) Node) *Block {
+	node := Rewrite(block, f)
+	if b, ok := node.(*Block); ok {
+		return b
+	}
+	panic(fmt.Sprintf("ast.Rewrite: %T cannot replace *ast.Block", node))
+}
+
+func rewriteFuncDefExp(exp *FuncDefExp, f func(Node) Node) *FuncDefExp {
+	node := Rewrite(exp, f)
+	if fd, ok := node.(*FuncDefExp); ok {
+		return fd
+	}
+	panic(fmt.Sprintf("ast.Rewrite: %T cannot replace *ast.FuncDefExp", node))
+}
+
+func rewriteNameExp(exp *StringExp, f func(Node) Node) *StringExp {
+	node := Rewrite(exp, f)
+	if s, ok := node.(*StringExp); ok {
+		return s
+	}
+	panic(fmt.Sprintf("ast.Rewrite: %T cannot replace *ast.StringExp", node))
+}
+
+func isStat(node Node) bool {
+	switch node.(type) {
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat, *DoStat,
+		*WhileStat, *RepeatStat, *IfStat, *ForNumStat, *ForInStat,
+		*AssignStat, *LocalVarDeclStat, *LocalFuncDefStat, *FuncCallStat:
+		return true
+	}
+	return false
+}
+
+func isExp(node Node) bool {
+	switch node.(type) {
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp,
+		*IntegerExp, *FloatExp, *StringExp, *NameExp,
+		*UnopExp, *BinopExp, *ConcatExp, *TableConstructorExp,
+		*FuncDefExp, *ParensExp, *TableAccessExp, *FuncCallExp:
+		return true
+	}
+	return false
+}
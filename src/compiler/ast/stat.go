@@ -17,35 +17,67 @@ stat ::=  ‘;’ |
 	 local function Name funcbody |
 	 local namelist [‘=’ explist]
 */
-type Stat interface{}
+type Stat interface {
+	Node
+}
+
+type FuncCallStat = FuncCallExp // functioncall
+
+// ‘;’
+type EmptyStat struct {
+	Span
+}
+
+// break
+type BreakStat struct {
+	Span
+	Line int
+}
+
+// do block end
+type DoStat struct {
+	Span
+	Block *Block
+}
 
-type EmptyStat struct{}              // ‘;’
-type BreakStat struct{ Line int }    // break
-type LabelStat struct{ Name string } // ‘::’ Name ‘::’
-type GotoStat struct{ Name string }  // goto Name
-type DoStat struct{ Block *Block }   // do block end
-type FuncCallStat = FuncCallExp      // functioncall
+// ‘::’ Name ‘::’
+type LabelStat struct {
+	Span
+	Line int
+	Name string
+}
+
+// goto Name
+type GotoStat struct {
+	Span
+	Line int
+	Name string
+}
 
 // if exp then block {elseif exp then block} [else block] end
 type IfStat struct {
+	Span
 	Exps   []Exp
 	Blocks []*Block
 }
 
 // while exp do block end
 type WhileStat struct {
+	Span
 	Exp   Exp
 	Block *Block
 }
 
 // repeat block until exp
 type RepeatStat struct {
+	Span
 	Block *Block
 	Exp   Exp
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
 type ForNumStat struct {
+	Span
 	LineOfFor int
 	LineOfDo  int
 	VarName   string
@@ -59,6 +91,7 @@ type ForNumStat struct {
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
 type ForInStat struct {
+	Span
 	LineOfDo int
 	NameList []string
 	ExpList  []Exp
@@ -69,6 +102,7 @@ type ForInStat struct {
 // varlist ::= var {‘,’ var}
 // var ::=  Name | prefixexp ‘[’ exp ‘]’ | prefixexp ‘.’ Name
 type AssignStat struct {
+	Span
 	LastLine int
 	VarList  []Exp
 	ExpList  []Exp
@@ -78,6 +112,7 @@ type AssignStat struct {
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
 type LocalVarDeclStat struct {
+	Span
 	LastLine int
 	NameList []string
 	ExpList  []Exp
@@ -85,6 +120,7 @@ type LocalVarDeclStat struct {
 
 // local function Name funcbody
 type LocalFuncDefStat struct {
+	Span
 	Name string
 	Exp  *FuncDefExp
 }
@@ -0,0 +1,121 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order, visiting the children
+// of a node in source order.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Block:
+		walkStatList(v, n.Stats)
+		walkExpList(v, n.RetExps)
+
+	/* statements */
+	case *EmptyStat, *BreakStat, *LabelStat, *GotoStat:
+		// nothing to do
+	case *DoStat:
+		Walk(v, n.Block)
+	case *WhileStat:
+		Walk(v, n.Exp)
+		Walk(v, n.Block)
+	case *RepeatStat:
+		Walk(v, n.Block)
+		Walk(v, n.Exp)
+	case *IfStat:
+		for i, exp := range n.Exps {
+			Walk(v, exp)
+			Walk(v, n.Blocks[i])
+		}
+	case *ForNumStat:
+		Walk(v, n.InitExp)
+		Walk(v, n.LimitExp)
+		Walk(v, n.StepExp)
+		Walk(v, n.Block)
+	case *ForInStat:
+		walkExpList(v, n.ExpList)
+		Walk(v, n.Block)
+	case *AssignStat:
+		walkExpList(v, n.VarList)
+		walkExpList(v, n.ExpList)
+	case *LocalVarDeclStat:
+		walkExpList(v, n.ExpList)
+	case *LocalFuncDefStat:
+		Walk(v, n.Exp)
+
+	/* expressions */
+	case *NilExp, *TrueExp, *FalseExp, *VarargExp,
+		*IntegerExp, *FloatExp, *StringExp, *NameExp:
+		// nothing to do
+	case *UnopExp:
+		Walk(v, n.Exp)
+	case *BinopExp:
+		Walk(v, n.Exp1)
+		Walk(v, n.Exp2)
+	case *ConcatExp:
+		walkExpList(v, n.Exps)
+	case *TableConstructorExp:
+		for i, valExp := range n.ValExps {
+			if n.KeyExps[i] != nil {
+				Walk(v, n.KeyExps[i])
+			}
+			Walk(v, valExp)
+		}
+	case *FuncDefExp:
+		Walk(v, n.Block)
+	case *ParensExp:
+		Walk(v, n.Exp)
+	case *TableAccessExp:
+		Walk(v, n.PrefixExp)
+		Walk(v, n.KeyExp)
+	case *FuncCallExp:
+		Walk(v, n.PrefixExp)
+		if n.NameExp != nil {
+			Walk(v, n.NameExp)
+		}
+		walkExpList(v, n.Args)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatList(v Visitor, list []Stat) {
+	for _, stat := range list {
+		Walk(v, stat)
+	}
+}
+
+func walkExpList(v Visitor, list []Exp) {
+	for _, exp := range list {
+		Walk(v, exp)
+	}
+}
+
+type inspector func(Node) bool
+
+func (self inspector) Visit(node Node) Visitor {
+	if self(node) {
+		return self
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: it starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// for each of the children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,216 @@
+package ast_test
+
+import "fmt"
+import "strings"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/compiler/ast"
+import "github.com/tdkr/go-luavm/src/compiler/parser"
+
+/* uses every kind of node */
+const allNodes = `
+local a, b = nil, true
+local function f(...) return ... end
+x, t.y, t[1] = false, 42, 4.2
+::top::
+do goto top end
+while a do break end
+repeat a = not a until a
+if a then elseif b then else end
+for i = 1, 10, 2 do end
+for k, v in pairs(t) do end
+print(-a .. "s" .. b, a + b, (f()), {1, k = 2, [3] = 4}, function() end)
+obj:method(a)
+`
+
+var allTypes = []string{
+	"*ast.Block",
+	"*ast.EmptyStat", "*ast.BreakStat", "*ast.LabelStat", "*ast.GotoStat",
+	"*ast.DoStat", "*ast.WhileStat", "*ast.RepeatStat", "*ast.IfStat",
+	"*ast.ForNumStat", "*ast.ForInStat", "*ast.AssignStat",
+	"*ast.LocalVarDeclStat", "*ast.LocalFuncDefStat",
+	"*ast.NilExp", "*ast.TrueExp", "*ast.FalseExp", "*ast.VarargExp",
+	"*ast.IntegerExp", "*ast.FloatExp", "*ast.StringExp", "*ast.NameExp",
+	"*ast.UnopExp", "*ast.BinopExp", "*ast.ConcatExp",
+	"*ast.TableConstructorExp", "*ast.FuncDefExp", "*ast.ParensExp",
+	"*ast.TableAccessExp", "*ast.FuncCallExp",
+}
+
+// the parser drops empty statements, so one is added by hand
+func parseAll(t *testing.T) *Block {
+	block := parse(t, allNodes)
+	block.Stats = append(block.Stats, &EmptyStat{})
+	return block
+}
+
+func parse(t *testing.T, chunk string) *Block {
+	block, err := parser.ParseString(chunk, "=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+type counter struct {
+	types map[string]int
+	nodes int
+	nils  int
+}
+
+func (self *counter) Visit(node Node) Visitor {
+	if node == nil {
+		self.nils++
+	} else {
+		self.types[fmt.Sprintf("%T", node)]++
+		self.nodes++
+	}
+	return self
+}
+
+func TestWalk(t *testing.T) {
+	v := &counter{types: map[string]int{}}
+	Walk(v, parseAll(t))
+	for _, typ := range allTypes {
+		if v.types[typ] == 0 {
+			t.Errorf("%s not visited", typ)
+		}
+	}
+	if v.nils != v.nodes {
+		t.Errorf("%d nodes but %d calls of Visit(nil)", v.nodes, v.nils)
+	}
+}
+
+func TestWalkOrder(t *testing.T) {
+	var visited []string
+	Inspect(parse(t, "local x = a + f(b, 1)"), func(node Node) bool {
+		switch n := node.(type) {
+		case nil:
+			visited = append(visited, ")")
+		case *NameExp:
+			visited = append(visited, n.Name)
+		case *IntegerExp:
+			visited = append(visited, fmt.Sprint(n.Val))
+		default:
+			visited = append(visited, strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+		}
+		return true
+	})
+	want := "Block LocalVarDeclStat BinopExp a ) FuncCallExp f ) b ) 1 ) ) ) ) )"
+	if got := strings.Join(visited, " "); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestInspectSkip(t *testing.T) {
+	n := 0
+	Inspect(parse(t, allNodes), func(node Node) bool {
+		if node != nil {
+			n++
+		}
+		_, isFunc := node.(*FuncDefExp)
+		return !isFunc
+	})
+	all := 0
+	Inspect(parse(t, allNodes), func(node Node) bool {
+		if node != nil {
+			all++
+		}
+		return true
+	})
+	if n >= all {
+		t.Errorf("function bodies were not skipped: %d nodes of %d", n, all)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	block := parseAll(t)
+	v := &counter{types: map[string]int{}}
+	Walk(v, block)
+
+	calls := 0
+	Rewrite(block, func(node Node) Node {
+		calls++
+		return node
+	})
+	if calls != v.nodes {
+		t.Errorf("f called %d times for %d nodes", calls, v.nodes)
+	}
+}
+
+func TestRewriteReplace(t *testing.T) {
+	block := parse(t, "print(1, 2 + 3) do end x = 4")
+	Rewrite(block, func(node Node) Node {
+		switch n := node.(type) {
+		case *IntegerExp:
+			n.Val *= 10
+		case *BinopExp:
+			return &StringExp{Span: n.Span, Line: n.Line, Str: "sum"}
+		case *DoStat:
+			return nil
+		}
+		return node
+	})
+
+	if len(block.Stats) != 2 {
+		t.Fatalf("got %d statements, want 2", len(block.Stats))
+	}
+	call := block.Stats[0].(*FuncCallStat)
+	if call.Args[0].(*IntegerExp).Val != 10 || call.Args[1].(*StringExp).Str != "sum" {
+		t.Errorf("arguments not rewritten: %#v", call.Args)
+	}
+	if block.Stats[1].(*AssignStat).ExpList[0].(*IntegerExp).Val != 40 {
+		t.Error("assignment not rewritten")
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(Node) Node
+		want string
+	}{
+		{"nil expression", replace(isInt, nil),
+			"ast.Rewrite: <nil> cannot replace an expression"},
+		{"statement for expression", replace(isInt, &EmptyStat{}),
+			"ast.Rewrite: *ast.EmptyStat cannot replace an expression"},
+		{"expression for statement", replace(isDo, &NilExp{}),
+			"ast.Rewrite: *ast.NilExp cannot replace a statement"},
+		{"statement for block", replace(isBlock, &EmptyStat{}),
+			"ast.Rewrite: *ast.EmptyStat cannot replace *ast.Block"},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r != test.want {
+					t.Errorf("%s: got %v, want %s", test.name, r, test.want)
+				}
+			}()
+			Rewrite(parse(t, "do local x = 1 end"), test.f)
+		}()
+	}
+}
+
+// replaces the nodes for which match is true with repl
+func replace(match func(Node) bool, repl Node) func(Node) Node {
+	return func(node Node) Node {
+		if match(node) {
+			return repl
+		}
+		return node
+	}
+}
+
+func isInt(node Node) bool {
+	_, ok := node.(*IntegerExp)
+	return ok
+}
+
+func isDo(node Node) bool {
+	_, ok := node.(*DoStat)
+	return ok
+}
+
+// only the body of the do statement, not the chunk
+func isBlock(node Node) bool {
+	b, ok := node.(*Block)
+	return ok && len(b.Stats) == 1 && b.Pos().Line == 1 && b.Pos().Column > 1
+}
@@ -3,13 +3,41 @@ package codegen
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 
 func cgBlock(fi *funcInfo, node *Block) {
-	for _, stat := range node.Stats {
-		cgStat(fi, stat)
+	cgBlockX(fi, node, false)
+}
+
+// locals of the body of a 'repeat' stay visible in the 'until'
+// condition, so a label ending it is not treated as the block end
+func cgRepeatBlock(fi *funcInfo, node *Block) {
+	cgBlockX(fi, node, true)
+}
+
+func cgBlockX(fi *funcInfo, node *Block, isRepeat bool) {
+	fi.enterBlock(isRepeat)
+	for i, stat := range node.Stats {
+		if label, ok := stat.(*LabelStat); ok {
+			atBlockEnd := node.RetExps == nil && onlyLabels(node.Stats[i+1:])
+			fi.addLabel(label.Name, label.Line, atBlockEnd)
+		} else {
+			cgStat(fi, stat)
+		}
 	}
 
 	if node.RetExps != nil {
 		cgRetStat(fi, node.RetExps, node.LastLine)
 	}
+	fi.leaveBlock()
+}
+
+// labels are no-op statements, they may follow the label that
+// ends a block
+func onlyLabels(stats []Stat) bool {
+	for _, stat := range stats {
+		if _, ok := stat.(*LabelStat); !ok {
+			return false
+		}
+	}
+	return true
 }
 
 func cgRetStat(fi *funcInfo, exps []Exp, lastLine int) {
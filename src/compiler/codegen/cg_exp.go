@@ -54,7 +54,12 @@ func cgExp(fi *funcInfo, node Exp, a, n int) {
 
 func cgVarargExp(fi *funcInfo, node *VarargExp, a, n int) {
 	if !fi.isVararg {
-		panic("cannot use '...' outside a vararg function")
+		panic(&SyntaxError{
+			Line:   node.Line,
+			Column: node.Pos().Column,
+			Msg:    "cannot use '...' outside a vararg function",
+			Near:   "'...'",
+		})
 	}
 	fi.emitVararg(node.Line, a, n)
 }
@@ -188,9 +193,10 @@ func cgNameExp(fi *funcInfo, node *NameExp, a int) {
 		fi.emitGetUpval(node.Line, a, idx)
 	} else { // x => _ENV['x']
 		taExp := &TableAccessExp{
+			Span:      node.Span,
 			LastLine:  node.Line,
-			PrefixExp: &NameExp{node.Line, "_ENV"},
-			KeyExp:    &StringExp{node.Line, node.Name},
+			PrefixExp: &NameExp{node.Span, node.Line, "_ENV"},
+			KeyExp:    &StringExp{node.Span, node.Line, node.Name},
 		}
 		cgTableAccessExp(fi, taExp, a)
 	}
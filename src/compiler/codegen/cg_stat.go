@@ -26,8 +26,8 @@ func cgStat(fi *funcInfo, node Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *LabelStat, *GotoStat:
-		panic("label and goto statements are not supported!")
+	case *GotoStat:
+		cgGotoStat(fi, stat)
 	}
 }
 
@@ -47,6 +47,10 @@ func cgBreakStat(fi *funcInfo, node *BreakStat) {
 	fi.addBreakJmp(pc)
 }
 
+func cgGotoStat(fi *funcInfo, node *GotoStat) {
+	fi.addGoto(node.Name, node.Line)
+}
+
 func cgDoStat(fi *funcInfo, node *DoStat) {
 	fi.enterScope(false)
 	cgBlock(fi, node.Block)
@@ -93,7 +97,7 @@ func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	fi.enterScope(true)
 
 	pcBeforeBlock := fi.pc()
-	cgBlock(fi, node.Block)
+	cgRepeatBlock(fi, node.Block)
 
 	oldRegs := fi.usedRegs
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
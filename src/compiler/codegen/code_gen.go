@@ -5,6 +5,7 @@ import . "github.com/tdkr/go-luavm/src/compiler/ast"
 
 func GenProto(chunk *Block) *Prototype {
 	fd := &FuncDefExp{
+		Span:     chunk.Span,
 		LastLine: chunk.LastLine,
 		IsVararg: true,
 		Block:    chunk,
@@ -1,5 +1,6 @@
 package codegen
 
+import "fmt"
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 import . "github.com/tdkr/go-luavm/src/vm"
@@ -35,6 +36,24 @@ type locVarInfo struct {
 	captured bool
 }
 
+// description of a pending goto or an active label
+// lua-5.3.4/src/lparser.h#Labeldesc
+type labelInfo struct {
+	name    string
+	pc      int /* position in code */
+	line    int /* line where it appeared */
+	nActVar int /* local level where it appears in current block */
+}
+
+// nodes for block list (list of active blocks)
+// lua-5.3.4/src/lparser.c#BlockCnt
+type blockInfo struct {
+	firstLabel int  /* index of first label in this block */
+	firstGoto  int  /* index of first pending goto in this block */
+	nActVar    int  /* # active locals outside the block */
+	isRepeat   bool /* true if the block is the body of a 'repeat' */
+}
+
 type funcInfo struct {
 	parent    *funcInfo
 	subFuncs  []*funcInfo
@@ -46,6 +65,9 @@ type funcInfo struct {
 	upvalues  map[string]upvalInfo
 	constants map[interface{}]int
 	breaks    [][]int
+	blocks    []blockInfo
+	labels    []labelInfo
+	gotos     []labelInfo
 	insts     []uint32
 	lineNums  []uint32
 	line      int
@@ -72,6 +94,20 @@ func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
 	}
 }
 
+// raises a *SyntaxError, its source is filled in by the compiler
+// lua-5.3.4/src/lparser.c#semerror()
+func (self *funcInfo) error(line int, f string, a ...interface{}) {
+	panic(&SyntaxError{Line: line, Msg: fmt.Sprintf(f, a...)})
+}
+
+// line of the last emitted instruction
+func (self *funcInfo) currentLine() int {
+	if n := len(self.lineNums); n > 0 {
+		return int(self.lineNums[n-1])
+	}
+	return self.line
+}
+
 /* constants */
 
 func (self *funcInfo) indexOfConstant(k interface{}) int {
@@ -89,7 +125,8 @@ func (self *funcInfo) indexOfConstant(k interface{}) int {
 func (self *funcInfo) allocReg() int {
 	self.usedRegs++
 	if self.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+		self.error(self.currentLine(),
+			"function or expression needs too many registers")
 	}
 	if self.usedRegs > self.maxRegs {
 		self.maxRegs = self.usedRegs
@@ -196,7 +233,141 @@ func (self *funcInfo) addBreakJmp(pc int) {
 		}
 	}
 
-	panic("<break> at line ? not inside a loop!")
+	line := int(self.lineNums[pc])
+	self.error(line, "<break> at line %d not inside a loop", line)
+}
+
+/* labels and gotos */
+
+func (self *funcInfo) enterBlock(isRepeat bool) {
+	self.blocks = append(self.blocks, blockInfo{
+		firstLabel: len(self.labels),
+		firstGoto:  len(self.gotos),
+		nActVar:    self.usedRegs,
+		isRepeat:   isRepeat,
+	})
+}
+
+// lua-5.3.4/src/lparser.c#leaveblock()
+func (self *funcInfo) leaveBlock() {
+	bl := self.blocks[len(self.blocks)-1]
+	upval := self.hasCapturedLocVars(bl.nActVar)
+	self.blocks = self.blocks[:len(self.blocks)-1]
+	self.labels = self.labels[:bl.firstLabel] /* remove local labels */
+	if len(self.blocks) > 0 {
+		self.moveGotosOut(bl, upval) /* update pending gotos to outer block */
+	} else if len(self.gotos) > 0 { /* pending gotos in outer block? */
+		gt := self.gotos[0]
+		self.error(gt.line, "no visible label '%s' for <goto> at line %d",
+			gt.name, gt.line)
+	}
+}
+
+// "export" pending gotos to outer level, to check them against
+// outer labels; if the block being exited has upvalues, and
+// the goto exits the scope of any variable (which can be the
+// upvalue), close those variables being exited.
+// lua-5.3.4/src/lparser.c#movegotosout()
+func (self *funcInfo) moveGotosOut(bl blockInfo, upval bool) {
+	for i := bl.firstGoto; i < len(self.gotos); {
+		gt := &self.gotos[i]
+		if gt.nActVar > bl.nActVar {
+			if upval {
+				self.fixJmpA(gt.pc, bl.nActVar+1)
+			}
+			gt.nActVar = bl.nActVar
+		}
+		if !self.findLabel(i) {
+			i++ /* move to next one */
+		}
+	}
+}
+
+// lua-5.3.4/src/lparser.c#labelstat()
+func (self *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	bl := self.blocks[len(self.blocks)-1]
+	for _, lb := range self.labels[bl.firstLabel:] { /* check for repeated labels */
+		if lb.name == name {
+			self.error(line, "label '%s' already defined on line %d",
+				name, lb.line)
+		}
+	}
+
+	lb := labelInfo{name, self.pc() + 1, line, self.usedRegs}
+	if atBlockEnd && !bl.isRepeat {
+		/* assume that locals are already out of scope */
+		lb.nActVar = bl.nActVar
+	}
+	self.labels = append(self.labels, lb)
+
+	/* solve pending gotos to new label */
+	for i := bl.firstGoto; i < len(self.gotos); {
+		if self.gotos[i].name == name {
+			self.closeGoto(i, lb)
+		} else {
+			i++
+		}
+	}
+}
+
+// lua-5.3.4/src/lparser.c#gotostat()
+func (self *funcInfo) addGoto(name string, line int) {
+	pc := self.emitJmp(line, 0, 0)
+	self.gotos = append(self.gotos, labelInfo{name, pc, line, self.usedRegs})
+	self.findLabel(len(self.gotos) - 1)
+}
+
+// try to close a goto with existing labels; this solves backward jumps
+// lua-5.3.4/src/lparser.c#findlabel()
+func (self *funcInfo) findLabel(g int) bool {
+	bl := self.blocks[len(self.blocks)-1]
+	gt := self.gotos[g]
+	/* check labels in current block for a match */
+	for _, lb := range self.labels[bl.firstLabel:] {
+		if lb.name == gt.name { /* correct label? */
+			if gt.nActVar > lb.nActVar { /* leaving the scope of a variable? */
+				self.fixJmpA(gt.pc, lb.nActVar+1) /* close it */
+			}
+			self.closeGoto(g, lb)
+			return true
+		}
+	}
+	return false /* label not found; cannot close goto */
+}
+
+// lua-5.3.4/src/lparser.c#closegoto()
+func (self *funcInfo) closeGoto(g int, lb labelInfo) {
+	gt := self.gotos[g]
+	if gt.nActVar < lb.nActVar {
+		varName := self.locVarOfSlot(gt.nActVar).name
+		self.error(lb.line, "<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, varName)
+	}
+	self.fixSbx(gt.pc, lb.pc-gt.pc-1)
+	/* remove goto from pending list */
+	self.gotos = append(self.gotos[:g], self.gotos[g+1:]...)
+}
+
+func (self *funcInfo) locVarOfSlot(slot int) *locVarInfo {
+	for _, locVar := range self.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func (self *funcInfo) hasCapturedLocVars(minSlot int) bool {
+	for _, locVar := range self.locNames {
+		for v := locVar; v != nil && v.slot >= minSlot; v = v.prev {
+			if v.captured {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 /* upvalues */
@@ -263,6 +434,14 @@ func (self *funcInfo) fixSbx(pc, sBx int) {
 	self.insts[pc] = i
 }
 
+// set the upvalue-close operand of the jump at pc
+func (self *funcInfo) fixJmpA(pc, a int) {
+	i := self.insts[pc]
+	i = i &^ (0xFF << 6) // clear a
+	i = i | uint32(a)<<6 // reset a
+	self.insts[pc] = i
+}
+
 // todo: rename?
 func (self *funcInfo) fixEndPC(name string, delta int) {
 	for i := len(self.locVars) - 1; i >= 0; i-- {
@@ -0,0 +1,75 @@
+package codegen
+
+import "testing"
+import . "github.com/tdkr/go-luavm/src/compiler/lexer"
+import "github.com/tdkr/go-luavm/src/compiler/parser"
+
+// compiles chunk, returns the syntax error raised if any
+func compile(chunk string) (err *SyntaxError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(*SyntaxError)
+		}
+	}()
+
+	GenProto(parser.Parse(chunk, "=t"))
+	return nil
+}
+
+func TestGoto(t *testing.T) {
+	for _, chunk := range []string{
+		/* continue */
+		"for i = 1, 3 do if i == 2 then goto continue end local x = i ::continue:: end",
+		"while true do local x goto continue; local y ::continue:: end",
+		/* backward jumps */
+		"do local i = 1 ::top:: local x = i i = i + 1 if i < 3 then goto top end end",
+		"::top:: goto top",
+		/* forward jumps out of nested blocks and loops */
+		"for i = 1, 3 do for j = 1, 3 do goto done end end ::done::",
+		"do do do goto out end end end ::out::",
+		/* a label at the end of a block is outside the scope of its locals */
+		"do goto e; local x ::e:: end",
+		"do goto e; local x ::e:: ; ; ::f:: end",
+		/* labels with the same name in nested functions and blocks */
+		"do ::a:: do ::a:: end end do ::a:: end",
+		"::a:: local function f() ::a:: goto a end goto a",
+		/* the label is visible in nested blocks */
+		"::l:: do do goto l end end",
+		/* 'goto' is only a keyword before a name */
+		"goto x; ::x::",
+	} {
+		if err := compile(chunk); err != nil {
+			t.Errorf("%q: %s", chunk, err.Message())
+		}
+	}
+}
+
+func TestGotoErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		line  int
+		msg   string
+	}{
+		{"goto l1; local a; ::l1:: print(a)", 1,
+			"<goto l1> at line 1 jumps into the scope of local 'a'"},
+		{"::l1::\n::l1::", 2, "label 'l1' already defined on line 1"},
+		{"goto nowhere", 1, "no visible label 'nowhere' for <goto> at line 1"},
+		{"do ::l2:: end\ngoto l2", 2, "no visible label 'l2' for <goto> at line 2"},
+		{"repeat goto l3; local x ::l3:: until x", 1,
+			"<goto l3> at line 1 jumps into the scope of local 'x'"},
+		{"local function f()\ngoto l4\nend ::l4::", 2,
+			"no visible label 'l4' for <goto> at line 2"},
+		{"::l5:: local function f() goto l5 end", 1,
+			"no visible label 'l5' for <goto> at line 1"},
+		{"for i = 1, 2 do end\nbreak", 2, "<break> at line 2 not inside a loop"},
+	}
+	for _, test := range tests {
+		err := compile(test.chunk)
+		if err == nil {
+			t.Errorf("%q: compiled", test.chunk)
+		} else if err.Line != test.line || err.Message() != test.msg {
+			t.Errorf("%q: got %d: %s, want %d: %s",
+				test.chunk, err.Line, err.Message(), test.line, test.msg)
+		}
+	}
+}
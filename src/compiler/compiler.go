@@ -1,14 +1,57 @@
 package compiler
 
+import "io"
 import "github.com/tdkr/go-luavm/src/binchunk"
+import "github.com/tdkr/go-luavm/src/compiler/ast"
 import "github.com/tdkr/go-luavm/src/compiler/codegen"
+import "github.com/tdkr/go-luavm/src/compiler/lexer"
 import "github.com/tdkr/go-luavm/src/compiler/parser"
 
-func Compile(chunk, chunkName string) *binchunk.Prototype {
-	ast := parser.Parse(chunk, chunkName)
-	proto := codegen.GenProto(ast)
+// SyntaxError is the error returned by Compile for chunks that
+// cannot be compiled.
+type SyntaxError = lexer.SyntaxError
+
+// ReadError is the error returned by CompileReader when
+// the reader fails.
+type ReadError = lexer.ReadError
+
+// Compiles a text chunk. Errors in the chunk are returned
+// as a *SyntaxError.
+func Compile(chunk, chunkName string) (*binchunk.Prototype, error) {
+	return compile(func() *ast.Block {
+		return parser.Parse(chunk, chunkName)
+	}, chunkName)
+}
+
+// Compiles a text chunk read from r, without keeping its source in
+// memory. Errors in the chunk are returned as a *SyntaxError,
+// errors returned by r as a *ReadError.
+func CompileReader(r io.Reader, chunkName string) (*binchunk.Prototype, error) {
+	return compile(func() *ast.Block {
+		return parser.ParseReader(r, chunkName)
+	}, chunkName)
+}
+
+func compile(parse func() *ast.Block, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch x := r.(type) {
+			case *SyntaxError:
+				if x.Source == "" { /* raised by the code generator */
+					x.Source = chunkName
+				}
+				proto, err = nil, x
+			case *ReadError:
+				proto, err = nil, x
+			default:
+				panic(r) /* not a user error */
+			}
+		}
+	}()
+
+	proto = codegen.GenProto(parse())
 	setSource(proto, chunkName)
-	return proto
+	return proto, nil
 }
 
 func setSource(proto *binchunk.Prototype, chunkName string) {
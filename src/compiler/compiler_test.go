@@ -0,0 +1,60 @@
+package compiler
+
+import "testing"
+
+/* messages as load in Lua 5.3 reports them */
+func TestSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		chunk  string
+		msg    string
+		line   int
+		column int
+	}{
+		/* unfinished strings */
+		{"x = 'abc", `[string "x = 'abc"]:1: unfinished string near <eof>`, 1, 5},
+		{"x = 'abc\ny'", `[string "x = 'abc..."]:1: unfinished string near ''abc'`, 1, 5},
+		{`x = "a\zb`, `[string "x = "a\zb"]:1: unfinished string near <eof>`, 1, 5},
+		{`x = "\q"`, `[string "x = "\q""]:1: invalid escape sequence near '"\q'`, 1, 5},
+		{`x = '\300'`, `[string "x = '\300'"]:1: decimal escape too large near ''\300'`, 1, 5},
+		{`x = "\x5g"`, `[string "x = "\x5g""]:1: hexadecimal digit expected near '"\x5g'`, 1, 5},
+		/* malformed numbers */
+		{"x = 3..2", `[string "x = 3..2"]:1: malformed number near '3..2'`, 1, 5},
+		{"x = 0x", `[string "x = 0x"]:1: malformed number near '0x'`, 1, 5},
+		{"x = 1e+", `[string "x = 1e+"]:1: malformed number near '1e+'`, 1, 5},
+		{"\n  y = 12abc", `[string "..."]:2: malformed number near '12abc'`, 2, 7},
+		/* long brackets */
+		{"x = [==[abc]=]", `[string "x = [==[abc]=]"]:1: unfinished long string (starting at line 1) near <eof>`, 1, 5},
+		{"x = [[\n\nabc", `[string "x = [[..."]:3: unfinished long string (starting at line 1) near <eof>`, 3, 5},
+		{"--[==[ x\n", `[string "--[==[ x..."]:2: unfinished long comment (starting at line 1) near <eof>`, 2, 1},
+		{"x = [=abc", `[string "x = [=abc"]:1: invalid long string delimiter near '[='`, 1, 5},
+		/* near <eof> */
+		{"f(", `[string "f("]:1: unexpected symbol near <eof>`, 1, 3},
+		{"x =", `[string "x ="]:1: unexpected symbol near <eof>`, 1, 4},
+		{"return 1 +\n", `[string "return 1 +..."]:2: unexpected symbol near <eof>`, 2, 1},
+		{"if x then", `[string "if x then"]:1: 'end' expected near <eof>`, 1, 10},
+		{"local function", `[string "local function"]:1: <name> expected near <eof>`, 1, 15},
+		/* other parser errors */
+		{"x = }", `[string "x = }"]:1: unexpected symbol near '}'`, 1, 5},
+		{"local 1", `[string "local 1"]:1: <name> expected near '1'`, 1, 7},
+		{"for i = 1 do end", `[string "for i = 1 do end"]:1: ',' expected near 'do'`, 1, 11},
+		{"a.b:c = 1", `[string "a.b:c = 1"]:1: function arguments expected near '='`, 1, 7},
+		{"x = function() end end", `[string "x = function() end end"]:1: <eof> expected near 'end'`, 1, 20},
+		{"x = @", `[string "x = @"]:1: unexpected symbol near '@'`, 1, 5},
+		{"function f() return ... end", `[string "function f() return ... end"]:1: cannot use '...' outside a vararg function near '...'`, 1, 21},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.chunk, test.chunk)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%q: got %v", test.chunk, err)
+			continue
+		}
+		if se.Error() != test.msg {
+			t.Errorf("%q:\ngot  %s\nwant %s", test.chunk, se.Error(), test.msg)
+		}
+		if se.Line != test.line || se.Column != test.column {
+			t.Errorf("%q: got %d:%d, want %d:%d",
+				test.chunk, se.Line, se.Column, test.line, test.column)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package lexer
+
+import "fmt"
+import "strings"
+
+const LUA_IDSIZE = 60 /* size of a chunk id, including the terminator */
+
+// SyntaxError is raised (as a panic) by the lexer, the parser and the
+// code generator when a chunk cannot be compiled.
+type SyntaxError struct {
+	Source string // chunk name
+	Line   int    // line where the error was found
+	Column int    // column (1-based, in bytes) where it was found; 0 if unknown
+	Msg    string
+	Near   string // offending token, already quoted; "" if none
+}
+
+// Returns the message without its position, e.g.
+// "'=' expected near 'x'".
+func (self *SyntaxError) Message() string {
+	if self.Near == "" {
+		return self.Msg
+	}
+	return fmt.Sprintf("%s near %s", self.Msg, self.Near)
+}
+
+// Returns the message as load reports it, e.g.
+// `[string "x = = 1"]:1: unexpected symbol near '='`.
+func (self *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", ChunkID(self.Source), self.Line, self.Message())
+}
+
+// ReadError is raised (as a panic) by a lexer reading its chunk
+// from an io.Reader when the reader fails.
+type ReadError struct {
+	Err error
+}
+
+func (self *ReadError) Error() string {
+	return self.Err.Error()
+}
+
+// ChunkID formats a chunk name for messages: "=name" is used as is,
+// "@file" names a file and anything else is the source itself.
+// lua-5.3.4/src/lobject.c#luaO_chunkid()
+func ChunkID(source string) string {
+	bufflen := LUA_IDSIZE - 1
+	if strings.HasPrefix(source, "=") { /* 'literal' source */
+		source = source[1:]
+		if len(source) > bufflen {
+			source = source[:bufflen] /* truncate it */
+		}
+		return source
+	}
+	if strings.HasPrefix(source, "@") { /* file name */
+		source = source[1:]
+		if len(source) > bufflen { /* must truncate? */
+			source = "..." + source[len(source)-bufflen+3:]
+		}
+		return source
+	}
+	/* string; format as [string "source"] */
+	bufflen -= len(`[string "..."]`)
+	nl := strings.IndexByte(source, '\n')
+	if len(source) < bufflen && nl < 0 { /* small one-line source? */
+		return `[string "` + source + `"]`
+	}
+	if nl >= 0 {
+		source = source[:nl] /* stop at first newline */
+	}
+	if len(source) > bufflen {
+		source = source[:bufflen]
+	}
+	return `[string "` + source + `..."]`
+}
+
+// lua-5.3.4/src/llex.c#luaX_token2str()
+func tokenToStr(kind int) string {
+	s := tokenNames[kind]
+	if kind < TOKEN_IDENTIFIER && kind != TOKEN_EOF { /* fixed format? */
+		return "'" + s + "'"
+	}
+	return s /* names, strings, numerals and <eof> */
+}
+
+// lua-5.3.4/src/llex.c#txtToken()
+func txtToken(kind int, token string) string {
+	switch kind {
+	case TOKEN_IDENTIFIER, TOKEN_STRING, TOKEN_NUMBER:
+		return "'" + token + "'"
+	default:
+		return tokenToStr(kind)
+	}
+}
@@ -1,49 +1,81 @@
 package lexer
 
-import "bytes"
 import "fmt"
-import "regexp"
-import "strconv"
+import "io"
 import "strings"
+import "github.com/tdkr/go-luavm/src/number"
 
-//var reSpaces = regexp.MustCompile(`^\s+`)
-var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
-var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`)
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)
-var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)
+/* hand-written scanner, a port of llex.c */
 
-var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)
-var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)
-var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u\{[0-9a-fA-F]+\}`)
+// size of the first read of a chunk read from an io.Reader
+const minReadSize = 4096
 
 type Lexer struct {
-	chunk         string // source code
-	chunkName     string // source name
-	line          int    // current line number
+	chunk         string    // source code, or the part of it read and not yet scanned
+	chunkName     string    // source name
+	reader        io.Reader // where the rest of the chunk comes from, if any
+	eof           bool      // reader is exhausted
+	base          int       // offset of chunk[0] in the source
+	pos           int       // index in chunk of the next byte to scan
+	line          int       // current line number
+	lineStart     int       // index in chunk of the first byte of the current line
+	start, end    Position  // range of the last token
 	nextToken     string
 	nextTokenKind int
 	nextTokenLine int
+	nextStart     Position
+	nextEnd       Position
+	buf           []byte // for strings with escape sequences
 }
 
 func NewLexer(chunk, chunkName string) *Lexer {
-	return &Lexer{chunk, chunkName, 1, "", 0, 0}
+	return &Lexer{chunk: chunk, chunkName: chunkName, line: 1}
 }
 
+// Returns a lexer that reads the chunk from r as it scans, keeping
+// only the token being scanned in memory. Errors returned by r
+// are raised (as a panic) as a *ReadError.
+func NewReaderLexer(r io.Reader, chunkName string) *Lexer {
+	return &Lexer{reader: r, chunkName: chunkName, line: 1}
+}
+
+// Returns the line of the last token.
 func (self *Lexer) Line() int {
 	return self.line
 }
 
+// Returns the column (1-based, in bytes) where the last token starts.
+func (self *Lexer) Column() int {
+	return self.start.Column
+}
+
+// Returns the position of the first byte of the last token.
+func (self *Lexer) Pos() Position {
+	return self.start
+}
+
+// Returns the position just past the last token.
+func (self *Lexer) End() Position {
+	return self.end
+}
+
+// Returns the position of the first byte of the next token.
+func (self *Lexer) NextPos() Position {
+	self.LookAhead()
+	return self.nextStart
+}
+
 func (self *Lexer) LookAhead() int {
 	if self.nextTokenLine > 0 {
 		return self.nextTokenKind
 	}
-	currentLine := self.line
+	currentLine, start, end := self.line, self.start, self.end
 	line, kind, token := self.NextToken()
-	self.line = currentLine
 	self.nextTokenLine = line
+	self.nextStart, self.nextEnd = self.start, self.end
 	self.nextTokenKind = kind
 	self.nextToken = token
+	self.line, self.start, self.end = currentLine, start, end
 	return kind
 }
 
@@ -52,365 +84,595 @@ func (self *Lexer) NextIdentifier() (line int, token string) {
 }
 
 func (self *Lexer) NextTokenOfKind(kind int) (line int, token string) {
-	line, _kind, token := self.NextToken()
-	if kind != _kind {
-		self.error("syntax error near '%s'", token)
+	if self.LookAhead() != kind {
+		self.Error("%s expected", tokenToStr(kind))
 	}
+	line, _, token = self.NextToken()
 	return line, token
 }
 
+// Error raises a *SyntaxError near the next token.
+func (self *Lexer) Error(f string, a ...interface{}) {
+	kind := self.LookAhead()
+	panic(&SyntaxError{
+		Source: self.chunkName,
+		Line:   self.nextTokenLine,
+		Column: self.nextStart.Column,
+		Msg:    fmt.Sprintf(f, a...),
+		Near:   txtToken(kind, self.nextToken),
+	})
+}
+
+// lua-5.3.4/src/llex.c#llex()
 func (self *Lexer) NextToken() (line, kind int, token string) {
 	if self.nextTokenLine > 0 {
 		line = self.nextTokenLine
 		kind = self.nextTokenKind
 		token = self.nextToken
 		self.line = self.nextTokenLine
+		self.start, self.end = self.nextStart, self.nextEnd
 		self.nextTokenLine = 0
 		return
 	}
 
 	self.skipWhiteSpaces()
-	if len(self.chunk) == 0 {
-		return self.line, TOKEN_EOF, "EOF"
+	self.discard()
+	self.start = self.position()
+	kind, token = self.scanToken()
+	self.end = self.position()
+	return self.line, kind, token
+}
+
+func (self *Lexer) position() Position {
+	return Position{self.base + self.pos, self.line, self.pos - self.lineStart + 1}
+}
+
+// reports whether the chunk has a byte n bytes ahead of pos,
+// reading more of it if needed
+func (self *Lexer) avail(n int) bool {
+	for self.pos+n >= len(self.chunk) {
+		if !self.fill() {
+			return false
+		}
+	}
+	return true
+}
+
+// appends the next part of the chunk read from the reader;
+// returns false at the end of the chunk
+func (self *Lexer) fill() bool {
+	if self.reader == nil || self.eof {
+		return false
+	}
+	size := len(self.chunk) /* grow geometrically to read long tokens in linear time */
+	if size < minReadSize {
+		size = minReadSize
+	}
+	buf := make([]byte, size)
+	n, err := io.ReadFull(self.reader, buf) /* short reads would make it quadratic */
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		self.eof = true
+	} else if err != nil {
+		panic(&ReadError{err})
+	}
+	self.chunk += string(buf[:n])
+	return n > 0
+}
+
+// forgets the bytes already scanned, which no index refers to
+// between tokens
+func (self *Lexer) discard() {
+	if self.reader != nil && self.pos > 0 {
+		self.chunk = self.chunk[self.pos:]
+		self.base += self.pos
+		self.lineStart -= self.pos
+		self.pos = 0
+	}
+}
+
+func (self *Lexer) scanToken() (kind int, token string) {
+	if !self.avail(0) {
+		return TOKEN_EOF, "EOF"
 	}
 
-	switch self.chunk[0] {
+	switch c := self.chunk[self.pos]; c {
 	case ';':
-		self.next(1)
-		return self.line, TOKEN_SEP_SEMI, ";"
+		return self.fixed(TOKEN_SEP_SEMI, ";")
 	case ',':
-		self.next(1)
-		return self.line, TOKEN_SEP_COMMA, ","
+		return self.fixed(TOKEN_SEP_COMMA, ",")
 	case '(':
-		self.next(1)
-		return self.line, TOKEN_SEP_LPAREN, "("
+		return self.fixed(TOKEN_SEP_LPAREN, "(")
 	case ')':
-		self.next(1)
-		return self.line, TOKEN_SEP_RPAREN, ")"
+		return self.fixed(TOKEN_SEP_RPAREN, ")")
 	case ']':
-		self.next(1)
-		return self.line, TOKEN_SEP_RBRACK, "]"
+		return self.fixed(TOKEN_SEP_RBRACK, "]")
 	case '{':
-		self.next(1)
-		return self.line, TOKEN_SEP_LCURLY, "{"
+		return self.fixed(TOKEN_SEP_LCURLY, "{")
 	case '}':
-		self.next(1)
-		return self.line, TOKEN_SEP_RCURLY, "}"
+		return self.fixed(TOKEN_SEP_RCURLY, "}")
 	case '+':
-		self.next(1)
-		return self.line, TOKEN_OP_ADD, "+"
+		return self.fixed(TOKEN_OP_ADD, "+")
 	case '-':
-		self.next(1)
-		return self.line, TOKEN_OP_MINUS, "-"
+		return self.fixed(TOKEN_OP_MINUS, "-")
 	case '*':
-		self.next(1)
-		return self.line, TOKEN_OP_MUL, "*"
+		return self.fixed(TOKEN_OP_MUL, "*")
 	case '^':
-		self.next(1)
-		return self.line, TOKEN_OP_POW, "^"
+		return self.fixed(TOKEN_OP_POW, "^")
 	case '%':
-		self.next(1)
-		return self.line, TOKEN_OP_MOD, "%"
+		return self.fixed(TOKEN_OP_MOD, "%")
 	case '&':
-		self.next(1)
-		return self.line, TOKEN_OP_BAND, "&"
+		return self.fixed(TOKEN_OP_BAND, "&")
 	case '|':
-		self.next(1)
-		return self.line, TOKEN_OP_BOR, "|"
+		return self.fixed(TOKEN_OP_BOR, "|")
 	case '#':
-		self.next(1)
-		return self.line, TOKEN_OP_LEN, "#"
+		return self.fixed(TOKEN_OP_LEN, "#")
 	case ':':
 		if self.test("::") {
-			self.next(2)
-			return self.line, TOKEN_SEP_LABEL, "::"
-		} else {
-			self.next(1)
-			return self.line, TOKEN_SEP_COLON, ":"
+			return self.fixed(TOKEN_SEP_LABEL, "::")
 		}
+		return self.fixed(TOKEN_SEP_COLON, ":")
 	case '/':
 		if self.test("//") {
-			self.next(2)
-			return self.line, TOKEN_OP_IDIV, "//"
-		} else {
-			self.next(1)
-			return self.line, TOKEN_OP_DIV, "/"
+			return self.fixed(TOKEN_OP_IDIV, "//")
 		}
+		return self.fixed(TOKEN_OP_DIV, "/")
 	case '~':
 		if self.test("~=") {
-			self.next(2)
-			return self.line, TOKEN_OP_NE, "~="
-		} else {
-			self.next(1)
-			return self.line, TOKEN_OP_WAVE, "~"
+			return self.fixed(TOKEN_OP_NE, "~=")
 		}
+		return self.fixed(TOKEN_OP_WAVE, "~")
 	case '=':
 		if self.test("==") {
-			self.next(2)
-			return self.line, TOKEN_OP_EQ, "=="
-		} else {
-			self.next(1)
-			return self.line, TOKEN_OP_ASSIGN, "="
+			return self.fixed(TOKEN_OP_EQ, "==")
 		}
+		return self.fixed(TOKEN_OP_ASSIGN, "=")
 	case '<':
 		if self.test("<<") {
-			self.next(2)
-			return self.line, TOKEN_OP_SHL, "<<"
+			return self.fixed(TOKEN_OP_SHL, "<<")
 		} else if self.test("<=") {
-			self.next(2)
-			return self.line, TOKEN_OP_LE, "<="
-		} else {
-			self.next(1)
-			return self.line, TOKEN_OP_LT, "<"
+			return self.fixed(TOKEN_OP_LE, "<=")
 		}
+		return self.fixed(TOKEN_OP_LT, "<")
 	case '>':
 		if self.test(">>") {
-			self.next(2)
-			return self.line, TOKEN_OP_SHR, ">>"
+			return self.fixed(TOKEN_OP_SHR, ">>")
 		} else if self.test(">=") {
-			self.next(2)
-			return self.line, TOKEN_OP_GE, ">="
-		} else {
-			self.next(1)
-			return self.line, TOKEN_OP_GT, ">"
+			return self.fixed(TOKEN_OP_GE, ">=")
 		}
+		return self.fixed(TOKEN_OP_GT, ">")
 	case '.':
 		if self.test("...") {
-			self.next(3)
-			return self.line, TOKEN_VARARG, "..."
+			return self.fixed(TOKEN_VARARG, "...")
 		} else if self.test("..") {
-			self.next(2)
-			return self.line, TOKEN_OP_CONCAT, ".."
-		} else if len(self.chunk) == 1 || !isDigit(self.chunk[1]) {
-			self.next(1)
-			return self.line, TOKEN_SEP_DOT, "."
+			return self.fixed(TOKEN_OP_CONCAT, "..")
+		} else if !isDigit(self.peek(1)) {
+			return self.fixed(TOKEN_SEP_DOT, ".")
 		}
+		return TOKEN_NUMBER, self.scanNumber()
 	case '[':
-		if self.test("[[") || self.test("[=") {
-			return self.line, TOKEN_STRING, self.scanLongString()
-		} else {
-			self.next(1)
-			return self.line, TOKEN_SEP_LBRACK, "["
+		if sep := self.skipSep(); sep >= 0 {
+			return TOKEN_STRING, self.scanLongString(sep, "string")
+		} else if sep != -1 {
+			self.errorNear(self.quote(self.chunk[self.pos:self.pos-sep]),
+				"invalid long string delimiter")
 		}
+		return self.fixed(TOKEN_SEP_LBRACK, "[")
 	case '\'', '"':
-		return self.line, TOKEN_STRING, self.scanShortString()
-	}
-
-	c := self.chunk[0]
-	if c == '.' || isDigit(c) {
-		token := self.scanNumber()
-		return self.line, TOKEN_NUMBER, token
-	}
-	if c == '_' || isLetter(c) {
-		token := self.scanIdentifier()
-		if kind, found := keywords[token]; found {
-			return self.line, kind, token // keyword
-		} else {
-			return self.line, TOKEN_IDENTIFIER, token
+		return TOKEN_STRING, self.scanShortString(c)
+	default:
+		if isDigit(c) {
+			return TOKEN_NUMBER, self.scanNumber()
+		}
+		if isLetter(c) {
+			token = self.scanIdentifier()
+			if kind, found := keywords[token]; found {
+				return kind, token // keyword
+			}
+			return TOKEN_IDENTIFIER, token
+		}
+		if c >= 0x20 && c < 0x7F { /* printable? */
+			self.errorNear(fmt.Sprintf("'%c'", c), "unexpected symbol")
 		}
+		self.errorNear(fmt.Sprintf("'<\\%d>'", c), "unexpected symbol")
+		panic("unreachable!")
 	}
-
-	self.error("unexpected symbol near %q", c)
-	return
 }
 
-func (self *Lexer) next(n int) {
-	self.chunk = self.chunk[n:]
+func (self *Lexer) fixed(kind int, token string) (int, string) {
+	self.pos += len(token)
+	return kind, token
 }
 
 func (self *Lexer) test(s string) bool {
-	return strings.HasPrefix(self.chunk, s)
+	self.avail(len(s) - 1)
+	return strings.HasPrefix(self.chunk[self.pos:], s)
+}
+
+// returns the byte n bytes ahead, or 0 at the end of the chunk
+func (self *Lexer) peek(n int) byte {
+	if self.avail(n) {
+		return self.chunk[self.pos+n]
+	}
+	return 0
+}
+
+// lua-5.3.4/src/llex.c#lexerror()
+func (self *Lexer) errorNear(near string, f string, a ...interface{}) {
+	panic(&SyntaxError{
+		Source: self.chunkName,
+		Line:   self.line,
+		Column: self.start.Column,
+		Msg:    fmt.Sprintf(f, a...),
+		Near:   near,
+	})
 }
 
-func (self *Lexer) error(f string, a ...interface{}) {
-	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", self.chunkName, self.line, err)
-	panic(err)
+func (self *Lexer) quote(s string) string {
+	return "'" + s + "'"
+}
+
+// skips a newline sequence (\n, \r, \n\r or \r\n)
+// lua-5.3.4/src/llex.c#inclinenumber()
+func (self *Lexer) newLine() {
+	old := self.chunk[self.pos]
+	self.pos++
+	if c := self.peek(0); isNewLine(c) && c != old {
+		self.pos++
+	}
+	self.line++
+	self.lineStart = self.pos
 }
 
 func (self *Lexer) skipWhiteSpaces() {
-	for len(self.chunk) > 0 {
-		if self.test("--") {
+	for self.avail(0) {
+		self.discard() /* long runs of comments are not kept */
+		switch c := self.chunk[self.pos]; c {
+		case '\n', '\r':
+			self.newLine()
+		case ' ', '\t', '\v', '\f':
+			self.pos++
+		case '-':
+			if self.peek(1) != '-' {
+				return
+			}
 			self.skipComment()
-		} else if self.test("\r\n") || self.test("\n\r") {
-			self.next(2)
-			self.line += 1
-		} else if isNewLine(self.chunk[0]) {
-			self.next(1)
-			self.line += 1
-		} else if isWhiteSpace(self.chunk[0]) {
-			self.next(1)
-		} else {
-			break
+		default:
+			return
 		}
 	}
 }
 
 func (self *Lexer) skipComment() {
-	self.next(2) // skip --
+	/* errors in a long comment point to its start */
+	self.start = self.position()
+	self.pos += 2 // skip --
 
 	// long comment ?
-	if self.test("[") {
-		if reOpeningLongBracket.FindString(self.chunk) != "" {
-			self.scanLongString()
+	if self.peek(0) == '[' {
+		if sep := self.skipSep(); sep >= 0 {
+			self.scanLongString(sep, "comment")
 			return
 		}
 	}
 
 	// short comment
-	for len(self.chunk) > 0 && !isNewLine(self.chunk[0]) {
-		self.next(1)
+	for self.avail(0) && !isNewLine(self.chunk[self.pos]) {
+		self.pos++
 	}
 }
 
 func (self *Lexer) scanIdentifier() string {
-	return self.scan(reIdentifier)
+	start := self.pos
+	for self.avail(0) && isAlnum(self.chunk[self.pos]) {
+		self.pos++
+	}
+	return self.chunk[start:self.pos]
 }
 
+// lua-5.3.4/src/llex.c#read_numeral()
 func (self *Lexer) scanNumber() string {
-	return self.scan(reNumber)
-}
+	start := self.pos
+	digits := isDigit
+	expo := byte('e')
+	if self.test("0x") || self.test("0X") { /* hexadecimal? */
+		self.pos += 2
+		digits = isHexDigit
+		expo = 'p'
+	}
+
+	ok := true
+	nDigits, nDots := 0, 0
+	for self.avail(0) {
+		c := self.chunk[self.pos]
+		if digits(c) {
+			nDigits++
+		} else if c == '.' {
+			nDots++
+		} else if c|0x20 == expo { /* exponent part? */
+			self.pos++
+			if c := self.peek(0); c == '+' || c == '-' {
+				self.pos++ /* optional exponent sign */
+			}
+			expStart := self.pos
+			for self.avail(0) && isDigit(self.chunk[self.pos]) {
+				self.pos++
+			}
+			ok = self.pos > expStart
+			break
+		} else {
+			break
+		}
+		self.pos++
+	}
+	/* a numeral touching a letter or a dot is malformed */
+	for self.avail(0) &&
+		(isAlnum(self.chunk[self.pos]) || self.chunk[self.pos] == '.') {
+		self.pos++
+		ok = false
+	}
 
-func (self *Lexer) scan(re *regexp.Regexp) string {
-	if token := re.FindString(self.chunk); token != "" {
-		self.next(len(token))
-		return token
+	token := self.chunk[start:self.pos]
+	if !ok || nDigits == 0 || nDots > 1 || !isNumeral(token) {
+		self.errorNear(self.quote(token), "malformed number")
 	}
-	panic("unreachable!")
+	return token
 }
 
-func (self *Lexer) scanLongString() string {
-	openingLongBracket := reOpeningLongBracket.FindString(self.chunk)
-	if openingLongBracket == "" {
-		self.error("invalid long string delimiter near '%s'",
-			self.chunk[0:2])
+// lua-5.3.4/src/lobject.c#luaO_str2num()
+func isNumeral(token string) bool {
+	if _, ok := number.ParseInteger(token); ok {
+		return true
 	}
+	_, ok := number.ParseFloat(token)
+	return ok
+}
 
-	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
-	closingLongBracketIdx := strings.Index(self.chunk, closingLongBracket)
-	if closingLongBracketIdx < 0 {
-		self.error("unfinished long string or comment")
+// Reads a sequence '[=*[' or ']=*]', leaving the last bracket.
+// Returns the number of '=' if the sequence is well formed, -1 if it
+// is a single bracket, or -(n+1) if it is a bracket followed by n '='
+// without the second bracket.
+// lua-5.3.4/src/llex.c#skip_sep()
+func (self *Lexer) skipSep() int {
+	s := self.chunk[self.pos]
+	n := 1
+	for self.peek(n) == '=' {
+		n++
 	}
+	if self.peek(n) == s {
+		return n - 1
+	}
+	return -n
+}
 
-	str := self.chunk[len(openingLongBracket):closingLongBracketIdx]
-	self.next(closingLongBracketIdx + len(closingLongBracket))
+// lua-5.3.4/src/llex.c#read_long_string()
+func (self *Lexer) scanLongString(sep int, what string) string {
+	line := self.line   /* initial line (for error message) */
+	self.pos += sep + 2 /* skip 2nd '[' */
+	if self.avail(0) && isNewLine(self.chunk[self.pos]) {
+		self.newLine() /* string starts with a newline? skip it */
+	}
 
-	str = reNewLine.ReplaceAllString(str, "\n")
-	self.line += strings.Count(str, "\n")
-	if len(str) > 0 && str[0] == '\n' {
-		str = str[1:]
+	start := self.pos
+	self.buf = self.buf[:0]
+	hasCR := false
+	for self.avail(0) {
+		switch c := self.chunk[self.pos]; c {
+		case ']':
+			if self.skipSep() == sep {
+				str := self.chunk[start:self.pos]
+				self.pos += sep + 2 /* skip 2nd ']' */
+				if hasCR {
+					return string(self.buf)
+				}
+				return str
+			}
+			self.pos++
+			if hasCR {
+				self.buf = append(self.buf, c)
+			}
+		case '\n', '\r':
+			if !hasCR && (c == '\r' || self.peek(1) == '\r') {
+				/* newlines are normalized to '\n' from now on */
+				hasCR = true
+				self.buf = append(self.buf, self.chunk[start:self.pos]...)
+			}
+			self.newLine()
+			if hasCR {
+				self.buf = append(self.buf, '\n')
+			}
+		default:
+			self.pos++
+			if hasCR {
+				self.buf = append(self.buf, c)
+			}
+		}
 	}
 
-	return str
+	self.errorNear(tokenToStr(TOKEN_EOF),
+		"unfinished long %s (starting at line %d)", what, line)
+	panic("unreachable!")
 }
 
-func (self *Lexer) scanShortString() string {
-	if str := reShortStr.FindString(self.chunk); str != "" {
-		self.next(len(str))
-		str = str[1 : len(str)-1]
-		if strings.Index(str, `\`) >= 0 {
-			self.line += len(reNewLine.FindAllString(str, -1))
-			str = self.escape(str)
+// lua-5.3.4/src/llex.c#read_string()
+func (self *Lexer) scanShortString(del byte) string {
+	start := self.pos
+	self.pos++ /* skip delimiter */
+	for {      /* fast path: no escape sequences */
+		if !self.avail(0) {
+			self.errorNear(tokenToStr(TOKEN_EOF), "unfinished string")
+		}
+		switch c := self.chunk[self.pos]; c {
+		case del:
+			self.pos++
+			return self.chunk[start+1 : self.pos-1]
+		case '\n', '\r':
+			self.errorNear(self.quote(self.chunk[start:self.pos]), "unfinished string")
+		case '\\':
+			self.buf = append(self.buf[:0], self.chunk[start+1:self.pos]...)
+			return self.scanEscapes(start, del)
 		}
-		return str
+		self.pos++
 	}
-	self.error("unfinished string")
-	return ""
 }
 
-func (self *Lexer) escape(str string) string {
-	var buf bytes.Buffer
-
-	for len(str) > 0 {
-		if str[0] != '\\' {
-			buf.WriteByte(str[0])
-			str = str[1:]
-			continue
+// continues reading a short string from its first escape sequence
+func (self *Lexer) scanEscapes(start int, del byte) string {
+	for {
+		if !self.avail(0) {
+			self.errorNear(tokenToStr(TOKEN_EOF), "unfinished string")
 		}
-
-		if len(str) == 1 {
-			self.error("unfinished string")
+		c := self.chunk[self.pos]
+		switch c {
+		case del:
+			self.pos++
+			return string(self.buf)
+		case '\n', '\r':
+			self.errorNear(self.quote(self.chunk[start:self.pos]), "unfinished string")
+		case '\\':
+			self.escape(start)
+		default:
+			self.buf = append(self.buf, c)
+			self.pos++
 		}
+	}
+}
 
-		switch str[1] {
-		case 'a':
-			buf.WriteByte('\a')
-			str = str[2:]
-			continue
-		case 'b':
-			buf.WriteByte('\b')
-			str = str[2:]
-			continue
-		case 'f':
-			buf.WriteByte('\f')
-			str = str[2:]
-			continue
-		case 'n', '\n':
-			buf.WriteByte('\n')
-			str = str[2:]
-			continue
-		case 'r':
-			buf.WriteByte('\r')
-			str = str[2:]
-			continue
-		case 't':
-			buf.WriteByte('\t')
-			str = str[2:]
-			continue
-		case 'v':
-			buf.WriteByte('\v')
-			str = str[2:]
-			continue
-		case '"':
-			buf.WriteByte('"')
-			str = str[2:]
-			continue
-		case '\'':
-			buf.WriteByte('\'')
-			str = str[2:]
-			continue
-		case '\\':
-			buf.WriteByte('\\')
-			str = str[2:]
-			continue
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': // \ddd
-			if found := reDecEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[1:], 10, 32)
-				if d <= 0xFF {
-					buf.WriteByte(byte(d))
-					str = str[len(found):]
-					continue
-				}
-				self.error("decimal escape too large near '%s'", found)
-			}
-		case 'x': // \xXX
-			if found := reHexEscapeSeq.FindString(str); found != "" {
-				d, _ := strconv.ParseInt(found[2:], 16, 32)
-				buf.WriteByte(byte(d))
-				str = str[len(found):]
-				continue
-			}
-		case 'u': // \u{XXX}
-			if found := reUnicodeEscapeSeq.FindString(str); found != "" {
-				d, err := strconv.ParseInt(found[3:len(found)-1], 16, 32)
-				if err == nil && d <= 0x10FFFF {
-					buf.WriteRune(rune(d))
-					str = str[len(found):]
-					continue
-				}
-				self.error("UTF-8 value too large near '%s'", found)
-			}
-		case 'z':
-			str = str[2:]
-			for len(str) > 0 && isWhiteSpace(str[0]) { // todo
-				str = str[1:]
+// reads the escape sequence at pos into buf
+func (self *Lexer) escape(start int) {
+	self.pos++ /* skip '\' */
+	c := self.peek(0)
+	switch c {
+	case 'a':
+		c = '\a'
+	case 'b':
+		c = '\b'
+	case 'f':
+		c = '\f'
+	case 'n':
+		c = '\n'
+	case 'r':
+		c = '\r'
+	case 't':
+		c = '\t'
+	case 'v':
+		c = '\v'
+	case '\\', '"', '\'':
+	case '\n', '\r':
+		self.newLine()
+		self.buf = append(self.buf, '\n')
+		return
+	case 'x':
+		self.buf = append(self.buf, byte(self.readHexEsc(start)))
+		return
+	case 'u':
+		self.utf8Esc(start)
+		return
+	case 'z': /* zap following span of spaces */
+		self.pos++
+		for self.avail(0) && isWhiteSpace(self.chunk[self.pos]) {
+			if isNewLine(self.chunk[self.pos]) {
+				self.newLine()
+			} else {
+				self.pos++
 			}
-			continue
 		}
-		self.error("invalid escape sequence near '\\%c'", str[1])
+		return
+	case 0:
+		if !self.avail(0) {
+			return /* will raise an error next loop */
+		}
+		fallthrough
+	default:
+		if !isDigit(c) {
+			self.escError(start, 1, "invalid escape sequence")
+		}
+		self.buf = append(self.buf, self.readDecEsc(start))
+		return
+	}
+	self.buf = append(self.buf, c)
+	self.pos++
+}
+
+// raises an error near the string read so far, up to n bytes past pos
+// lua-5.3.4/src/llex.c#esccheck()
+func (self *Lexer) escError(start, n int, msg string) {
+	end := self.pos + n
+	if end > len(self.chunk) {
+		end = len(self.chunk)
+	}
+	self.errorNear(self.quote(self.chunk[start:end]), msg)
+}
+
+// lua-5.3.4/src/llex.c#gethexa()
+func (self *Lexer) hexDigit(start, n int) int {
+	c := self.peek(n)
+	if !isHexDigit(c) {
+		self.escError(start, n+1, "hexadecimal digit expected")
+	}
+	if isDigit(c) {
+		return int(c - '0')
+	}
+	return int(c|0x20-'a') + 10
+}
+
+// lua-5.3.4/src/llex.c#readhexaesc()
+func (self *Lexer) readHexEsc(start int) int {
+	r := self.hexDigit(start, 1)<<4 | self.hexDigit(start, 2)
+	self.pos += 3
+	return r
+}
+
+// lua-5.3.4/src/llex.c#readutf8esc()
+func (self *Lexer) utf8Esc(start int) {
+	if self.peek(1) != '{' {
+		self.escError(start, 2, "missing '{'")
+	}
+	r := self.hexDigit(start, 2) /* must have at least one digit */
+	n := 3
+	for isHexDigit(self.peek(n)) {
+		r = r<<4 | self.hexDigit(start, n)
+		if r > 0x7FFFFFFF {
+			self.escError(start, n+1, "UTF-8 value too large")
+		}
+		n++
+	}
+	if self.peek(n) != '}' {
+		self.escError(start, n+1, "missing '}'")
+	}
+	self.pos += n + 1
+	self.buf = utf8Esc(self.buf, r)
+}
+
+// lua-5.3.4/src/llex.c#readdecesc()
+func (self *Lexer) readDecEsc(start int) byte {
+	r, n := 0, 0
+	for ; n < 3 && isDigit(self.peek(n)); n++ { /* read up to 3 digits */
+		r = 10*r + int(self.peek(n)-'0')
 	}
+	if r > 0xFF {
+		self.escError(start, n, "decimal escape too large")
+	}
+	self.pos += n
+	return byte(r)
+}
 
-	return buf.String()
+// appends the UTF-8 sequence of x, which may take up to 6 bytes
+// lua-5.3.4/src/lobject.c#luaO_utf8esc()
+func utf8Esc(buf []byte, x int) []byte {
+	if x < 0x80 { /* ascii? */
+		return append(buf, byte(x))
+	}
+	var tmp [6]byte
+	n := 0
+	mfb := 0x3f   /* maximum that fits in first byte */
+	for x > mfb { /* need continuation bytes? */
+		tmp[5-n] = byte(0x80 | (x & 0x3f)) /* add continuation byte */
+		n++
+		x >>= 6   /* remove added bits */
+		mfb >>= 1 /* now there is one less bit available in first byte */
+	}
+	tmp[5-n] = byte((^mfb << 1) | x) /* add first byte */
+	return append(buf, tmp[5-n:]...)
 }
 
 func isWhiteSpace(c byte) bool {
@@ -429,6 +691,14 @@ func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c|0x20 >= 'a' && c|0x20 <= 'f'
+}
+
 func isLetter(c byte) bool {
-	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isAlnum(c byte) bool {
+	return isLetter(c) || isDigit(c)
 }
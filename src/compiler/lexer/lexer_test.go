@@ -0,0 +1,259 @@
+package lexer
+
+import "errors"
+import "strings"
+import "testing"
+import "testing/iotest"
+
+type token struct {
+	line  int
+	kind  int
+	token string
+}
+
+// scans the whole chunk, err is the message of the syntax error if any
+func scanAll(chunk string) (tokens []token, err string) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(*SyntaxError).Error()
+		}
+	}()
+
+	lexer := NewLexer(chunk, "=t")
+	for {
+		line, kind, tok := lexer.NextToken()
+		if kind == TOKEN_EOF {
+			return tokens, ""
+		}
+		tokens = append(tokens, token{line, kind, tok})
+	}
+}
+
+func TestScanStrings(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{`"plain"`, "plain"},
+		{`'it''s'`, "it"}, /* first token only */
+		{`"\a\b\f\n\r\t\v\\\"\'"`, "\a\b\f\n\r\t\v\\\"'"},
+		{`"\65\066\0671"`, "ABC1"},
+		{`"\x41\x7a\xFF"`, "Az\xff"},
+		{`"\u{48}\u{e9}\u{20AC}\u{10FFFF}"`, "Hé€\U0010ffff"},
+		{`"\u{7FFFFFFF}"`, "\xfd\xbf\xbf\xbf\xbf\xbf"},
+		{"\"a\\z  \n\t  b\"", "ab"},
+		{"\"a\\\nb\"", "a\nb"},
+		{"\"a\\\r\nb\"", "a\nb"},
+		{"[[\nfirst newline is skipped]]", "first newline is skipped"},
+		{"[[\r\nfirst newline is skipped]]", "first newline is skipped"},
+		{"[==[a]]b]=]c]==]", "a]]b]=]c"},
+		{"[[a\r\nb\n\rc\rd]]", "a\nb\nc\nd"},
+		{`[[no \n escapes]]`, `no \n escapes`},
+	}
+	for _, test := range tests {
+		tokens, err := scanAll(test.chunk)
+		if err != "" {
+			t.Errorf("%q: %s", test.chunk, err)
+		} else if tokens[0].kind != TOKEN_STRING || tokens[0].token != test.want {
+			t.Errorf("%q: got %q, want %q", test.chunk, tokens[0].token, test.want)
+		}
+	}
+}
+
+func TestScanNumbers(t *testing.T) {
+	for _, chunk := range []string{
+		"3", "345", "0xff", "0xBEBADA", "3.0", "3.1416", "314.16e-2",
+		"0.31416E1", "34e1", "0x0.1E", "0xA23p-4", "0X1.921FB54442D18P+1",
+		".5", "3.", "1e500", "0x7fffffffffffffffff",
+	} {
+		tokens, err := scanAll(chunk)
+		if err != "" {
+			t.Errorf("%q: %s", chunk, err)
+		} else if len(tokens) != 1 || tokens[0].kind != TOKEN_NUMBER || tokens[0].token != chunk {
+			t.Errorf("%q: got %v", chunk, tokens)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		/* malformed numerals */
+		{"x = 3..2", "t:1: malformed number near '3..2'"},
+		{"x = 1.2.3", "t:1: malformed number near '1.2.3'"},
+		{"x = 12abc", "t:1: malformed number near '12abc'"},
+		{"x = 0x", "t:1: malformed number near '0x'"},
+		{"x = 0xg", "t:1: malformed number near '0xg'"},
+		{"x = 1e", "t:1: malformed number near '1e'"},
+		{"x = 1e+", "t:1: malformed number near '1e+'"},
+		{"x = 0x1p", "t:1: malformed number near '0x1p'"},
+		/* strings */
+		{`x = "\q"`, `t:1: invalid escape sequence near '"\q'`},
+		{`x = "\256"`, `t:1: decimal escape too large near '"\256'`},
+		{`x = "\xAg"`, `t:1: hexadecimal digit expected near '"\xAg'`},
+		{`x = "\u{110000000}"`, `t:1: UTF-8 value too large near '"\u{110000000'`},
+		{`x = "\u48"`, `t:1: missing '{' near '"\u4'`},
+		{`x = "\u{48"`, `t:1: missing '}' near '"\u{48"'`},
+		{"x = 'abc\ny'", "t:1: unfinished string near ''abc'"},
+		{"x = 'abc", "t:1: unfinished string near <eof>"},
+		/* long brackets */
+		{"x = [==[abc]=]", "t:1: unfinished long string (starting at line 1) near <eof>"},
+		{"x = [=abc", "t:1: invalid long string delimiter near '[='"},
+		{"--[[ never\nclosed", "t:2: unfinished long comment (starting at line 1) near <eof>"},
+		/* other symbols */
+		{"x = @", "t:1: unexpected symbol near '@'"},
+		{"x = \x01", "t:1: unexpected symbol near '<\\1>'"},
+	}
+	for _, test := range tests {
+		if _, err := scanAll(test.chunk); err != test.want {
+			t.Errorf("%q: got %q, want %q", test.chunk, err, test.want)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	/* \n, \r, \r\n and \n\r each end one line */
+	tokens, err := scanAll("a\nb\rc\r\nd\n\re\n\nf --[[x\r\n]] g -- h\ni [[\n\n]] j")
+	if err != "" {
+		t.Fatal(err)
+	}
+	want := []token{
+		{1, TOKEN_IDENTIFIER, "a"}, {2, TOKEN_IDENTIFIER, "b"},
+		{3, TOKEN_IDENTIFIER, "c"}, {4, TOKEN_IDENTIFIER, "d"},
+		{5, TOKEN_IDENTIFIER, "e"}, {7, TOKEN_IDENTIFIER, "f"},
+		{8, TOKEN_IDENTIFIER, "g"}, {9, TOKEN_IDENTIFIER, "i"},
+		{11, TOKEN_STRING, "\n"}, {11, TOKEN_IDENTIFIER, "j"},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %v, want %v", tokens, want)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("token %d: got %v, want %v", i, tokens[i], want[i])
+		}
+	}
+}
+
+func TestScanPositions(t *testing.T) {
+	lexer := NewLexer("local  x =\n\t'abc' --c\n--[==[\n]==] y", "=t")
+	want := []struct{ start, end Position }{
+		{Position{0, 1, 1}, Position{5, 1, 6}},    /* local */
+		{Position{7, 1, 8}, Position{8, 1, 9}},    /* x */
+		{Position{9, 1, 10}, Position{10, 1, 11}}, /* = */
+		{Position{12, 2, 2}, Position{17, 2, 7}},  /* 'abc' */
+		{Position{34, 4, 6}, Position{35, 4, 7}},  /* y */
+		{Position{35, 4, 7}, Position{35, 4, 7}},  /* <eof> */
+	}
+	for i, w := range want {
+		lexer.NextToken()
+		if lexer.Pos() != w.start || lexer.End() != w.end {
+			t.Errorf("token %d: got %v-%v, want %v-%v",
+				i, lexer.Pos(), lexer.End(), w.start, w.end)
+		}
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"=stdin", "stdin"},
+		{"@file.lua", "file.lua"},
+		{"@" + string(make([]byte, 80)), "..." + string(make([]byte, 56))},
+		{"x = 1", `[string "x = 1"]`},
+		{"x = 1\ny = 2", `[string "x = 1..."]`},
+	}
+	for _, test := range tests {
+		if got := ChunkID(test.source); got != test.want {
+			t.Errorf("ChunkID(%q) = %q, want %q", test.source, got, test.want)
+		}
+	}
+
+	err := &SyntaxError{Source: "x = = 1", Line: 1, Column: 5,
+		Msg: "unexpected symbol", Near: "'='"}
+	if want := `[string "x = = 1"]:1: unexpected symbol near '='`; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+type scanned struct {
+	token
+	start, end Position
+}
+
+// scans the whole chunk with lexer, err is the error raised if any
+func scanLexer(lexer *Lexer) (tokens []scanned, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+
+	for {
+		line, kind, tok := lexer.NextToken()
+		if kind == TOKEN_EOF {
+			return tokens, nil
+		}
+		tokens = append(tokens, scanned{token{line, kind, tok}, lexer.Pos(), lexer.End()})
+	}
+}
+
+func TestScanReader(t *testing.T) {
+	long := strings.Repeat("x", 3*minReadSize)
+	for _, chunk := range []string{
+		"local  x =\n\t'abc' --c\n",
+		"a\nb\rc\r\nd\n\re\n\nf --[[x\r\n]] g -- h\ni [[\n\n]] j",
+		`s = "\65\u{48}\z   \x41" .. [==[a]]b]==] // 0x1p4 ... :: ~= >=`,
+		"s = [[" + long + "]] --[[" + long + "]] t = '" + long + "' " + long,
+		"x = 3..2",
+		"x = 'abc",
+		"x = [==[abc]=]",
+	} {
+		want, wantErr := scanLexer(NewLexer(chunk, "=t"))
+		for _, r := range []func() *Lexer{
+			func() *Lexer { return NewReaderLexer(strings.NewReader(chunk), "=t") },
+			func() *Lexer { return NewReaderLexer(iotest.OneByteReader(strings.NewReader(chunk)), "=t") },
+			func() *Lexer { return NewReaderLexer(iotest.DataErrReader(strings.NewReader(chunk)), "=t") },
+		} {
+			got, err := scanLexer(r())
+			if len(got) != len(want) || (err == nil) != (wantErr == nil) ||
+				err != nil && err.Error() != wantErr.Error() {
+				t.Errorf("%.40q: got %d tokens, %v, want %d tokens, %v",
+					chunk, len(got), err, len(want), wantErr)
+				continue
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%.40q: token %d: got %v, want %v", chunk, i, got[i], want[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (self *failingReader) Read(p []byte) (int, error) {
+	if self.data == "" {
+		return 0, self.err
+	}
+	n := copy(p, self.data)
+	self.data = self.data[n:]
+	return n, nil
+}
+
+func TestScanReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	lexer := NewReaderLexer(&failingReader{"x = 'abc", boom}, "=t")
+	_, err := scanLexer(lexer)
+	if re, ok := err.(*ReadError); !ok || re.Err != boom {
+		t.Errorf("got %v, want a *ReadError", err)
+	}
+}
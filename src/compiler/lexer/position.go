@@ -0,0 +1,14 @@
+package lexer
+
+import "fmt"
+
+// Position is a location in the source of a chunk.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (in bytes)
+}
+
+func (self Position) String() string {
+	return fmt.Sprintf("%d:%d", self.Line, self.Column)
+}
@@ -5,6 +5,34 @@ import "github.com/tdkr/go-luavm/src/number"
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 
+/* constant folding, done on the AST given to the code generator */
+
+// folds the constant expressions of a chunk, bottom-up
+func optimize(block *Block) *Block {
+	return Rewrite(block, optimizeNode).(*Block)
+}
+
+func optimizeNode(node Node) Node {
+	switch exp := node.(type) {
+	case *BinopExp:
+		switch exp.Op {
+		case TOKEN_OP_OR:
+			return optimizeLogicalOr(exp)
+		case TOKEN_OP_AND:
+			return optimizeLogicalAnd(exp)
+		case TOKEN_OP_BAND, TOKEN_OP_BOR, TOKEN_OP_BXOR,
+			TOKEN_OP_SHL, TOKEN_OP_SHR:
+			return optimizeBitwiseBinaryOp(exp)
+		case TOKEN_OP_ADD, TOKEN_OP_SUB, TOKEN_OP_MUL, TOKEN_OP_DIV,
+			TOKEN_OP_IDIV, TOKEN_OP_MOD, TOKEN_OP_POW:
+			return optimizeArithBinaryOp(exp)
+		}
+	case *UnopExp:
+		return optimizeUnaryOp(exp)
+	}
+	return node
+}
+
 func optimizeLogicalOr(exp *BinopExp) Exp {
 	if isTrue(exp.Exp1) {
 		return exp.Exp1 // true or x => true
@@ -30,15 +58,15 @@ func optimizeBitwiseBinaryOp(exp *BinopExp) Exp {
 		if j, ok := castToInt(exp.Exp2); ok {
 			switch exp.Op {
 			case TOKEN_OP_BAND:
-				return &IntegerExp{exp.Line, i & j}
+				return &IntegerExp{exp.Span, exp.Line, i & j}
 			case TOKEN_OP_BOR:
-				return &IntegerExp{exp.Line, i | j}
+				return &IntegerExp{exp.Span, exp.Line, i | j}
 			case TOKEN_OP_BXOR:
-				return &IntegerExp{exp.Line, i ^ j}
+				return &IntegerExp{exp.Span, exp.Line, i ^ j}
 			case TOKEN_OP_SHL:
-				return &IntegerExp{exp.Line, number.ShiftLeft(i, j)}
+				return &IntegerExp{exp.Span, exp.Line, number.ShiftLeft(i, j)}
 			case TOKEN_OP_SHR:
-				return &IntegerExp{exp.Line, number.ShiftRight(i, j)}
+				return &IntegerExp{exp.Span, exp.Line, number.ShiftRight(i, j)}
 			}
 		}
 	}
@@ -50,18 +78,18 @@ func optimizeArithBinaryOp(exp *BinopExp) Exp {
 		if y, ok := exp.Exp2.(*IntegerExp); ok {
 			switch exp.Op {
 			case TOKEN_OP_ADD:
-				return &IntegerExp{exp.Line, x.Val + y.Val}
+				return &IntegerExp{exp.Span, exp.Line, x.Val + y.Val}
 			case TOKEN_OP_SUB:
-				return &IntegerExp{exp.Line, x.Val - y.Val}
+				return &IntegerExp{exp.Span, exp.Line, x.Val - y.Val}
 			case TOKEN_OP_MUL:
-				return &IntegerExp{exp.Line, x.Val * y.Val}
+				return &IntegerExp{exp.Span, exp.Line, x.Val * y.Val}
 			case TOKEN_OP_IDIV:
 				if y.Val != 0 {
-					return &IntegerExp{exp.Line, number.IFloorDiv(x.Val, y.Val)}
+					return &IntegerExp{exp.Span, exp.Line, number.IFloorDiv(x.Val, y.Val)}
 				}
 			case TOKEN_OP_MOD:
 				if y.Val != 0 {
-					return &IntegerExp{exp.Line, number.IMod(x.Val, y.Val)}
+					return &IntegerExp{exp.Span, exp.Line, number.IMod(x.Val, y.Val)}
 				}
 			}
 		}
@@ -70,41 +98,31 @@ func optimizeArithBinaryOp(exp *BinopExp) Exp {
 		if g, ok := castToFloat(exp.Exp2); ok {
 			switch exp.Op {
 			case TOKEN_OP_ADD:
-				return &FloatExp{exp.Line, f + g}
+				return &FloatExp{exp.Span, exp.Line, f + g}
 			case TOKEN_OP_SUB:
-				return &FloatExp{exp.Line, f - g}
+				return &FloatExp{exp.Span, exp.Line, f - g}
 			case TOKEN_OP_MUL:
-				return &FloatExp{exp.Line, f * g}
+				return &FloatExp{exp.Span, exp.Line, f * g}
 			case TOKEN_OP_DIV:
 				if g != 0 {
-					return &FloatExp{exp.Line, f / g}
+					return &FloatExp{exp.Span, exp.Line, f / g}
 				}
 			case TOKEN_OP_IDIV:
 				if g != 0 {
-					return &FloatExp{exp.Line, number.FFloorDiv(f, g)}
+					return &FloatExp{exp.Span, exp.Line, number.FFloorDiv(f, g)}
 				}
 			case TOKEN_OP_MOD:
 				if g != 0 {
-					return &FloatExp{exp.Line, number.FMod(f, g)}
+					return &FloatExp{exp.Span, exp.Line, number.FMod(f, g)}
 				}
 			case TOKEN_OP_POW:
-				return &FloatExp{exp.Line, math.Pow(f, g)}
+				return &FloatExp{exp.Span, exp.Line, math.Pow(f, g)}
 			}
 		}
 	}
 	return exp
 }
 
-func optimizePow(exp Exp) Exp {
-	if binop, ok := exp.(*BinopExp); ok {
-		if binop.Op == TOKEN_OP_POW {
-			binop.Exp2 = optimizePow(binop.Exp2)
-		}
-		return optimizeArithBinaryOp(binop)
-	}
-	return exp
-}
-
 func optimizeUnaryOp(exp *UnopExp) Exp {
 	switch exp.Op {
 	case TOKEN_OP_UNM:
@@ -122,10 +140,12 @@ func optimizeUnm(exp *UnopExp) Exp {
 	switch x := exp.Exp.(type) { // number?
 	case *IntegerExp:
 		x.Val = -x.Val
+		x.Span = exp.Span
 		return x
 	case *FloatExp:
 		if x.Val != 0 {
 			x.Val = -x.Val
+			x.Span = exp.Span
 			return x
 		}
 	}
@@ -135,9 +155,9 @@ func optimizeUnm(exp *UnopExp) Exp {
 func optimizeNot(exp *UnopExp) Exp {
 	switch exp.Exp.(type) {
 	case *NilExp, *FalseExp: // false
-		return &TrueExp{exp.Line}
+		return &TrueExp{exp.Span, exp.Line}
 	case *TrueExp, *IntegerExp, *FloatExp, *StringExp: // true
-		return &FalseExp{exp.Line}
+		return &FalseExp{exp.Span, exp.Line}
 	default:
 		return exp
 	}
@@ -147,10 +167,11 @@ func optimizeBnot(exp *UnopExp) Exp {
 	switch x := exp.Exp.(type) { // number?
 	case *IntegerExp:
 		x.Val = ^x.Val
+		x.Span = exp.Span
 		return x
 	case *FloatExp:
 		if i, ok := number.FloatToInteger(x.Val); ok {
-			return &IntegerExp{x.Line, ^i}
+			return &IntegerExp{exp.Span, x.Line, ^i}
 		}
 	}
 	return exp
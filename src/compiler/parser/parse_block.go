@@ -5,9 +5,17 @@ import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 
 // block ::= {stat} [retstat]
 func parseBlock(lexer *Lexer) *Block {
+	start := lexer.NextPos()
+	stats := parseStats(lexer)
+	retExps := parseRetExps(lexer)
+	end := lexer.End()
+	if end.Offset < start.Offset { // empty block
+		end = start
+	}
 	return &Block{
-		Stats:    parseStats(lexer),
-		RetExps:  parseRetExps(lexer),
+		Span:     Span{StartPos: start, EndPos: end},
+		Stats:    stats,
+		RetExps:  retExps,
 		LastLine: lexer.Line(),
 	}
 }
@@ -1,5 +1,6 @@
 package parser
 
+import "strconv"
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 import "github.com/tdkr/go-luavm/src/number"
@@ -42,35 +43,39 @@ func parseExp(lexer *Lexer) Exp {
 
 // x or y
 func parseExp12(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp11(lexer)
 	for lexer.LookAhead() == TOKEN_OP_OR {
 		line, op, _ := lexer.NextToken()
-		lor := &BinopExp{line, op, exp, parseExp11(lexer)}
-		exp = optimizeLogicalOr(lor)
+		exp2 := parseExp11(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
 	return exp
 }
 
 // x and y
 func parseExp11(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp10(lexer)
 	for lexer.LookAhead() == TOKEN_OP_AND {
 		line, op, _ := lexer.NextToken()
-		land := &BinopExp{line, op, exp, parseExp10(lexer)}
-		exp = optimizeLogicalAnd(land)
+		exp2 := parseExp10(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
 	return exp
 }
 
 // compare
 func parseExp10(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp9(lexer)
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_LT, TOKEN_OP_GT, TOKEN_OP_NE,
 			TOKEN_OP_LE, TOKEN_OP_GE, TOKEN_OP_EQ:
 			line, op, _ := lexer.NextToken()
-			exp = &BinopExp{line, op, exp, parseExp9(lexer)}
+			exp2 := parseExp9(lexer)
+			exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 		default:
 			return exp
 		}
@@ -80,46 +85,50 @@ func parseExp10(lexer *Lexer) Exp {
 
 // x | y
 func parseExp9(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp8(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BOR {
 		line, op, _ := lexer.NextToken()
-		bor := &BinopExp{line, op, exp, parseExp8(lexer)}
-		exp = optimizeBitwiseBinaryOp(bor)
+		exp2 := parseExp8(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
 	return exp
 }
 
 // x ~ y
 func parseExp8(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp7(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BXOR {
 		line, op, _ := lexer.NextToken()
-		bxor := &BinopExp{line, op, exp, parseExp7(lexer)}
-		exp = optimizeBitwiseBinaryOp(bxor)
+		exp2 := parseExp7(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
 	return exp
 }
 
 // x & y
 func parseExp7(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp6(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BAND {
 		line, op, _ := lexer.NextToken()
-		band := &BinopExp{line, op, exp, parseExp6(lexer)}
-		exp = optimizeBitwiseBinaryOp(band)
+		exp2 := parseExp6(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
 	return exp
 }
 
 // shift
 func parseExp6(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp5(lexer)
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_SHL, TOKEN_OP_SHR:
 			line, op, _ := lexer.NextToken()
-			shx := &BinopExp{line, op, exp, parseExp5(lexer)}
-			exp = optimizeBitwiseBinaryOp(shx)
+			exp2 := parseExp5(lexer)
+			exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 		default:
 			return exp
 		}
@@ -129,6 +138,7 @@ func parseExp6(lexer *Lexer) Exp {
 
 // a .. b
 func parseExp5(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp4(lexer)
 	if lexer.LookAhead() != TOKEN_OP_CONCAT {
 		return exp
@@ -140,18 +150,20 @@ func parseExp5(lexer *Lexer) Exp {
 		line, _, _ = lexer.NextToken()
 		exps = append(exps, parseExp4(lexer))
 	}
-	return &ConcatExp{line, exps}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &ConcatExp{span, line, exps}
 }
 
 // x +/- y
 func parseExp4(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp3(lexer)
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_ADD, TOKEN_OP_SUB:
 			line, op, _ := lexer.NextToken()
-			arith := &BinopExp{line, op, exp, parseExp3(lexer)}
-			exp = optimizeArithBinaryOp(arith)
+			exp2 := parseExp3(lexer)
+			exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 		default:
 			return exp
 		}
@@ -161,13 +173,14 @@ func parseExp4(lexer *Lexer) Exp {
 
 // *, %, /, //
 func parseExp3(lexer *Lexer) Exp {
+	start := lexer.NextPos()
 	exp := parseExp2(lexer)
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_MUL, TOKEN_OP_MOD, TOKEN_OP_DIV, TOKEN_OP_IDIV:
 			line, op, _ := lexer.NextToken()
-			arith := &BinopExp{line, op, exp, parseExp2(lexer)}
-			exp = optimizeArithBinaryOp(arith)
+			exp2 := parseExp2(lexer)
+			exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 		default:
 			return exp
 		}
@@ -180,46 +193,49 @@ func parseExp2(lexer *Lexer) Exp {
 	switch lexer.LookAhead() {
 	case TOKEN_OP_UNM, TOKEN_OP_BNOT, TOKEN_OP_LEN, TOKEN_OP_NOT:
 		line, op, _ := lexer.NextToken()
-		exp := &UnopExp{line, op, parseExp2(lexer)}
-		return optimizeUnaryOp(exp)
+		start := lexer.Pos()
+		exp2 := parseExp2(lexer)
+		return &UnopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp2}
 	}
 	return parseExp1(lexer)
 }
 
 // x ^ y
 func parseExp1(lexer *Lexer) Exp { // pow is right associative
+	start := lexer.NextPos()
 	exp := parseExp0(lexer)
 	if lexer.LookAhead() == TOKEN_OP_POW {
 		line, op, _ := lexer.NextToken()
-		exp = &BinopExp{line, op, exp, parseExp2(lexer)}
+		exp2 := parseExp2(lexer)
+		exp = &BinopExp{Span{StartPos: start, EndPos: lexer.End()}, line, op, exp, exp2}
 	}
-	return optimizePow(exp)
+	return exp
 }
 
 func parseExp0(lexer *Lexer) Exp {
 	switch lexer.LookAhead() {
 	case TOKEN_VARARG: // ...
 		line, _, _ := lexer.NextToken()
-		return &VarargExp{line}
+		return &VarargExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line}
 	case TOKEN_KW_NIL: // nil
 		line, _, _ := lexer.NextToken()
-		return &NilExp{line}
+		return &NilExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line}
 	case TOKEN_KW_TRUE: // true
 		line, _, _ := lexer.NextToken()
-		return &TrueExp{line}
+		return &TrueExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line}
 	case TOKEN_KW_FALSE: // false
 		line, _, _ := lexer.NextToken()
-		return &FalseExp{line}
+		return &FalseExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line}
 	case TOKEN_STRING: // LiteralString
 		line, _, token := lexer.NextToken()
-		return &StringExp{line, token}
+		return &StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, token}
 	case TOKEN_NUMBER: // Numeral
 		return parseNumberExp(lexer)
 	case TOKEN_SEP_LCURLY: // tableconstructor
 		return parseTableConstructorExp(lexer)
 	case TOKEN_KW_FUNCTION: // functiondef
 		lexer.NextToken()
-		return parseFuncDefExp(lexer)
+		return parseFuncDefExp(lexer, lexer.Pos())
 	default: // prefixexp
 		return parsePrefixExp(lexer)
 	}
@@ -227,25 +243,28 @@ func parseExp0(lexer *Lexer) Exp {
 
 func parseNumberExp(lexer *Lexer) Exp {
 	line, _, token := lexer.NextToken()
-	if i, ok := number.ParseInteger(token); ok {
-		return &IntegerExp{line, i}
-	} else if f, ok := number.ParseFloat(token); ok {
-		return &FloatExp{line, f}
-	} else { // todo
-		panic("not a number: " + token)
+	span := Span{StartPos: lexer.Pos(), EndPos: lexer.End()}
+	if i, err := strconv.ParseInt(token, 10, 64); err == nil { // fast path
+		return &IntegerExp{Span: span, Line: line, Val: i}
+	} else if i, ok := number.ParseInteger(token); ok {
+		return &IntegerExp{Span: span, Line: line, Val: i}
 	}
+	f, _ := number.ParseFloat(token) /* checked by the lexer */
+	return &FloatExp{Span: span, Line: line, Val: f}
 }
 
 // functiondef ::= function funcbody
 // funcbody ::= ‘(’ [parlist] ‘)’ block end
-func parseFuncDefExp(lexer *Lexer) *FuncDefExp {
+// start is the position of the ‘function’ keyword.
+func parseFuncDefExp(lexer *Lexer, start Position) *FuncDefExp {
 	line := lexer.Line()                               // function
 	lexer.NextTokenOfKind(TOKEN_SEP_LPAREN)            // (
 	parList, isVararg := _parseParList(lexer)          // [parlist]
 	lexer.NextTokenOfKind(TOKEN_SEP_RPAREN)            // )
 	block := parseBlock(lexer)                         // block
 	lastLine, _ := lexer.NextTokenOfKind(TOKEN_KW_END) // end
-	return &FuncDefExp{line, lastLine, parList, isVararg, block}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &FuncDefExp{span, line, lastLine, parList, isVararg, block}
 }
 
 // [parlist]
@@ -279,10 +298,12 @@ func _parseParList(lexer *Lexer) (names []string, isVararg bool) {
 func parseTableConstructorExp(lexer *Lexer) *TableConstructorExp {
 	line := lexer.Line()
 	lexer.NextTokenOfKind(TOKEN_SEP_LCURLY)    // {
+	start := lexer.Pos()                       //
 	keyExps, valExps := _parseFieldList(lexer) // [fieldlist]
 	lexer.NextTokenOfKind(TOKEN_SEP_RCURLY)    // }
 	lastLine := lexer.Line()
-	return &TableConstructorExp{line, lastLine, keyExps, valExps}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &TableConstructorExp{span, line, lastLine, keyExps, valExps}
 }
 
 // fieldlist ::= field {fieldsep field} [fieldsep]
@@ -327,7 +348,7 @@ func _parseField(lexer *Lexer) (k, v Exp) {
 		if lexer.LookAhead() == TOKEN_OP_ASSIGN {
 			// Name ‘=’ exp => ‘[’ LiteralString ‘]’ = exp
 			lexer.NextToken()
-			k = &StringExp{nameExp.Line, nameExp.Name}
+			k = &StringExp{nameExp.Span, nameExp.Line, nameExp.Name}
 			v = parseExp(lexer)
 			return
 		}
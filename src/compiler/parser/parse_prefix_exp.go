@@ -16,45 +16,50 @@ prefixexp ::= Name
 */
 func parsePrefixExp(lexer *Lexer) Exp {
 	var exp Exp
-	if lexer.LookAhead() == TOKEN_IDENTIFIER {
+	start := lexer.NextPos()
+	switch lexer.LookAhead() {
+	case TOKEN_IDENTIFIER:
 		line, name := lexer.NextIdentifier() // Name
-		exp = &NameExp{line, name}
-	} else { // ‘(’ exp ‘)’
+		exp = &NameExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
+	case TOKEN_SEP_LPAREN: // ‘(’ exp ‘)’
 		exp = parseParensExp(lexer)
+	default:
+		lexer.Error("unexpected symbol")
 	}
-	return _finishPrefixExp(lexer, exp)
+	return _finishPrefixExp(lexer, start, exp)
 }
 
 func parseParensExp(lexer *Lexer) Exp {
 	lexer.NextTokenOfKind(TOKEN_SEP_LPAREN) // (
+	start := lexer.Pos()                    //
 	exp := parseExp(lexer)                  // exp
 	lexer.NextTokenOfKind(TOKEN_SEP_RPAREN) // )
 
 	switch exp.(type) {
 	case *VarargExp, *FuncCallExp, *NameExp, *TableAccessExp:
-		return &ParensExp{exp}
+		return &ParensExp{Span{StartPos: start, EndPos: lexer.End()}, exp}
 	}
 
 	// no need to keep parens
 	return exp
 }
 
-func _finishPrefixExp(lexer *Lexer, exp Exp) Exp {
+func _finishPrefixExp(lexer *Lexer, start Position, exp Exp) Exp {
 	for {
 		switch lexer.LookAhead() {
 		case TOKEN_SEP_LBRACK: // prefixexp ‘[’ exp ‘]’
 			lexer.NextToken()                       // ‘[’
 			keyExp := parseExp(lexer)               // exp
 			lexer.NextTokenOfKind(TOKEN_SEP_RBRACK) // ‘]’
-			exp = &TableAccessExp{lexer.Line(), exp, keyExp}
+			exp = &TableAccessExp{Span{StartPos: start, EndPos: lexer.End()}, lexer.Line(), exp, keyExp}
 		case TOKEN_SEP_DOT: // prefixexp ‘.’ Name
 			lexer.NextToken()                    // ‘.’
 			line, name := lexer.NextIdentifier() // Name
-			keyExp := &StringExp{line, name}
-			exp = &TableAccessExp{line, exp, keyExp}
+			keyExp := &StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
+			exp = &TableAccessExp{Span{StartPos: start, EndPos: lexer.End()}, line, exp, keyExp}
 		case TOKEN_SEP_COLON, // prefixexp ‘:’ Name args
 			TOKEN_SEP_LPAREN, TOKEN_SEP_LCURLY, TOKEN_STRING: // prefixexp args
-			exp = _finishFuncCallExp(lexer, exp)
+			exp = _finishFuncCallExp(lexer, start, exp)
 		default:
 			return exp
 		}
@@ -63,19 +68,20 @@ func _finishPrefixExp(lexer *Lexer, exp Exp) Exp {
 }
 
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
-func _finishFuncCallExp(lexer *Lexer, prefixExp Exp) *FuncCallExp {
+func _finishFuncCallExp(lexer *Lexer, start Position, prefixExp Exp) *FuncCallExp {
 	nameExp := _parseNameExp(lexer)
 	line := lexer.Line() // todo
 	args := _parseArgs(lexer)
 	lastLine := lexer.Line()
-	return &FuncCallExp{line, lastLine, prefixExp, nameExp, args}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &FuncCallExp{span, line, lastLine, prefixExp, nameExp, args}
 }
 
 func _parseNameExp(lexer *Lexer) *StringExp {
 	if lexer.LookAhead() == TOKEN_SEP_COLON {
 		lexer.NextToken()
 		line, name := lexer.NextIdentifier()
-		return &StringExp{line, name}
+		return &StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
 	}
 	return nil
 }
@@ -91,9 +97,11 @@ func _parseArgs(lexer *Lexer) (args []Exp) {
 		lexer.NextTokenOfKind(TOKEN_SEP_RPAREN)
 	case TOKEN_SEP_LCURLY: // ‘{’ [fieldlist] ‘}’
 		args = []Exp{parseTableConstructorExp(lexer)}
-	default: // LiteralString
+	case TOKEN_STRING: // LiteralString
 		line, str := lexer.NextTokenOfKind(TOKEN_STRING)
-		args = []Exp{&StringExp{line, str}}
+		args = []Exp{&StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, str}}
+	default:
+		lexer.Error("function arguments expected")
 	}
 	return
 }
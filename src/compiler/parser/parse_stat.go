@@ -3,8 +3,6 @@ package parser
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 
-var _statEmpty = &EmptyStat{}
-
 /*
 stat ::=  ‘;’
 	| break
@@ -54,55 +52,60 @@ func parseStat(lexer *Lexer) Stat {
 // ;
 func parseEmptyStat(lexer *Lexer) *EmptyStat {
 	lexer.NextTokenOfKind(TOKEN_SEP_SEMI)
-	return _statEmpty
+	return &EmptyStat{Span: Span{StartPos: lexer.Pos(), EndPos: lexer.End()}}
 }
 
 // break
 func parseBreakStat(lexer *Lexer) *BreakStat {
 	lexer.NextTokenOfKind(TOKEN_KW_BREAK)
-	return &BreakStat{lexer.Line()}
+	return &BreakStat{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, lexer.Line()}
 }
 
 // ‘::’ Name ‘::’
 func parseLabelStat(lexer *Lexer) *LabelStat {
 	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
-	_, name := lexer.NextIdentifier()      // name
+	start := lexer.Pos()                   //
+	line, name := lexer.NextIdentifier()   // name
 	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
-	return &LabelStat{name}
+	return &LabelStat{Span{StartPos: start, EndPos: lexer.End()}, line, name}
 }
 
 // goto Name
 func parseGotoStat(lexer *Lexer) *GotoStat {
-	lexer.NextTokenOfKind(TOKEN_KW_GOTO) // goto
-	_, name := lexer.NextIdentifier()    // name
-	return &GotoStat{name}
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_GOTO) // goto
+	start := lexer.Pos()                            //
+	_, name := lexer.NextIdentifier()               // name
+	return &GotoStat{Span{StartPos: start, EndPos: lexer.End()}, line, name}
 }
 
 // do block end
 func parseDoStat(lexer *Lexer) *DoStat {
 	lexer.NextTokenOfKind(TOKEN_KW_DO)  // do
+	start := lexer.Pos()                //
 	block := parseBlock(lexer)          // block
 	lexer.NextTokenOfKind(TOKEN_KW_END) // end
-	return &DoStat{block}
+	return &DoStat{Span{StartPos: start, EndPos: lexer.End()}, block}
 }
 
 // while exp do block end
 func parseWhileStat(lexer *Lexer) *WhileStat {
 	lexer.NextTokenOfKind(TOKEN_KW_WHILE) // while
+	start := lexer.Pos()                  //
 	exp := parseExp(lexer)                // exp
 	lexer.NextTokenOfKind(TOKEN_KW_DO)    // do
 	block := parseBlock(lexer)            // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)   // end
-	return &WhileStat{exp, block}
+	return &WhileStat{Span{StartPos: start, EndPos: lexer.End()}, exp, block}
 }
 
 // repeat block until exp
 func parseRepeatStat(lexer *Lexer) *RepeatStat {
 	lexer.NextTokenOfKind(TOKEN_KW_REPEAT) // repeat
+	start := lexer.Pos()                   //
 	block := parseBlock(lexer)             // block
 	lexer.NextTokenOfKind(TOKEN_KW_UNTIL)  // until
 	exp := parseExp(lexer)                 // exp
-	return &RepeatStat{block, exp}
+	return &RepeatStat{Span{StartPos: start, EndPos: lexer.End()}, block, exp}
 }
 
 // if exp then block {elseif exp then block} [else block] end
@@ -111,6 +114,7 @@ func parseIfStat(lexer *Lexer) *IfStat {
 	blocks := make([]*Block, 0, 4)
 
 	lexer.NextTokenOfKind(TOKEN_KW_IF)         // if
+	start := lexer.Pos()                       //
 	exps = append(exps, parseExp(lexer))       // exp
 	lexer.NextTokenOfKind(TOKEN_KW_THEN)       // then
 	blocks = append(blocks, parseBlock(lexer)) // block
@@ -124,29 +128,31 @@ func parseIfStat(lexer *Lexer) *IfStat {
 
 	// else block => elseif true then block
 	if lexer.LookAhead() == TOKEN_KW_ELSE {
-		lexer.NextToken()                           // else
-		exps = append(exps, &TrueExp{lexer.Line()}) //
-		blocks = append(blocks, parseBlock(lexer))  // block
+		lexer.NextToken() // else
+		span := Span{StartPos: lexer.End(), EndPos: lexer.End()}
+		exps = append(exps, &TrueExp{span, lexer.Line()}) //
+		blocks = append(blocks, parseBlock(lexer))        // block
 	}
 
 	lexer.NextTokenOfKind(TOKEN_KW_END) // end
-	return &IfStat{exps, blocks}
+	return &IfStat{Span{StartPos: start, EndPos: lexer.End()}, exps, blocks}
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
 // for namelist in explist do block end
 func parseForStat(lexer *Lexer) Stat {
 	lineOfFor, _ := lexer.NextTokenOfKind(TOKEN_KW_FOR)
+	start := lexer.Pos()
 	_, name := lexer.NextIdentifier()
 	if lexer.LookAhead() == TOKEN_OP_ASSIGN {
-		return _finishForNumStat(lexer, lineOfFor, name)
+		return _finishForNumStat(lexer, start, lineOfFor, name)
 	} else {
-		return _finishForInStat(lexer, name)
+		return _finishForInStat(lexer, start, name)
 	}
 }
 
 // for Name ‘=’ exp ‘,’ exp [‘,’ exp] do block end
-func _finishForNumStat(lexer *Lexer, lineOfFor int, varName string) *ForNumStat {
+func _finishForNumStat(lexer *Lexer, start Position, lineOfFor int, varName string) *ForNumStat {
 	lexer.NextTokenOfKind(TOKEN_OP_ASSIGN) // for name =
 	initExp := parseExp(lexer)             // exp
 	lexer.NextTokenOfKind(TOKEN_SEP_COMMA) // ,
@@ -157,28 +163,30 @@ func _finishForNumStat(lexer *Lexer, lineOfFor int, varName string) *ForNumStat
 		lexer.NextToken()         // ,
 		stepExp = parseExp(lexer) // exp
 	} else {
-		stepExp = &IntegerExp{lexer.Line(), 1}
+		span := Span{StartPos: lexer.End(), EndPos: lexer.End()}
+		stepExp = &IntegerExp{span, lexer.Line(), 1}
 	}
 
 	lineOfDo, _ := lexer.NextTokenOfKind(TOKEN_KW_DO) // do
 	block := parseBlock(lexer)                        // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)               // end
 
-	return &ForNumStat{lineOfFor, lineOfDo,
+	return &ForNumStat{Span{StartPos: start, EndPos: lexer.End()}, lineOfFor, lineOfDo,
 		varName, initExp, limitExp, stepExp, block}
 }
 
 // for namelist in explist do block end
 // namelist ::= Name {‘,’ Name}
 // explist ::= exp {‘,’ exp}
-func _finishForInStat(lexer *Lexer, name0 string) *ForInStat {
+func _finishForInStat(lexer *Lexer, start Position, name0 string) *ForInStat {
 	nameList := _finishNameList(lexer, name0)         // for namelist
 	lexer.NextTokenOfKind(TOKEN_KW_IN)                // in
 	expList := parseExpList(lexer)                    // explist
 	lineOfDo, _ := lexer.NextTokenOfKind(TOKEN_KW_DO) // do
 	block := parseBlock(lexer)                        // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)               // end
-	return &ForInStat{lineOfDo, nameList, expList, block}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &ForInStat{span, lineOfDo, nameList, expList, block}
 }
 
 // namelist ::= Name {‘,’ Name}
@@ -196,10 +204,11 @@ func _finishNameList(lexer *Lexer, name0 string) []string {
 // local namelist [‘=’ explist]
 func parseLocalAssignOrFuncDefStat(lexer *Lexer) Stat {
 	lexer.NextTokenOfKind(TOKEN_KW_LOCAL)
+	start := lexer.Pos()
 	if lexer.LookAhead() == TOKEN_KW_FUNCTION {
-		return _finishLocalFuncDefStat(lexer)
+		return _finishLocalFuncDefStat(lexer, start)
 	} else {
-		return _finishLocalVarDeclStat(lexer)
+		return _finishLocalVarDeclStat(lexer, start)
 	}
 }
 
@@ -218,15 +227,16 @@ not to `local f = function () body end`
  contains references to f.)
 */
 // local function Name funcbody
-func _finishLocalFuncDefStat(lexer *Lexer) *LocalFuncDefStat {
+func _finishLocalFuncDefStat(lexer *Lexer, start Position) *LocalFuncDefStat {
 	lexer.NextTokenOfKind(TOKEN_KW_FUNCTION) // local function
+	fnStart := lexer.Pos()                   //
 	_, name := lexer.NextIdentifier()        // name
-	fdExp := parseFuncDefExp(lexer)          // funcbody
-	return &LocalFuncDefStat{name, fdExp}
+	fdExp := parseFuncDefExp(lexer, fnStart) // funcbody
+	return &LocalFuncDefStat{Span{StartPos: start, EndPos: lexer.End()}, name, fdExp}
 }
 
 // local namelist [‘=’ explist]
-func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
+func _finishLocalVarDeclStat(lexer *Lexer, start Position) *LocalVarDeclStat {
 	_, name0 := lexer.NextIdentifier()        // local Name
 	nameList := _finishNameList(lexer, name0) // { , Name }
 	var expList []Exp = nil
@@ -235,7 +245,8 @@ func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
 		expList = parseExpList(lexer) // explist
 	}
 	lastLine := lexer.Line()
-	return &LocalVarDeclStat{lastLine, nameList, expList}
+	span := Span{StartPos: start, EndPos: lexer.End()}
+	return &LocalVarDeclStat{span, lastLine, nameList, expList}
 }
 
 // varlist ‘=’ explist
@@ -244,8 +255,13 @@ func parseAssignOrFuncCallStat(lexer *Lexer) Stat {
 	prefixExp := parsePrefixExp(lexer)
 	if fc, ok := prefixExp.(*FuncCallExp); ok {
 		return fc
-	} else {
+	}
+	switch lexer.LookAhead() {
+	case TOKEN_OP_ASSIGN, TOKEN_SEP_COMMA:
 		return parseAssignStat(lexer, prefixExp)
+	default: /* stat -> func */
+		lexer.Error("syntax error")
+		panic("unreachable!")
 	}
 }
 
@@ -255,7 +271,8 @@ func parseAssignStat(lexer *Lexer, var0 Exp) *AssignStat {
 	lexer.NextTokenOfKind(TOKEN_OP_ASSIGN) // =
 	expList := parseExpList(lexer)         // explist
 	lastLine := lexer.Line()
-	return &AssignStat{lastLine, varList, expList}
+	span := Span{StartPos: var0.Pos(), EndPos: lexer.End()}
+	return &AssignStat{span, lastLine, varList, expList}
 }
 
 // varlist ::= var {‘,’ var}
@@ -275,7 +292,7 @@ func _checkVar(lexer *Lexer, exp Exp) Exp {
 	case *NameExp, *TableAccessExp:
 		return exp
 	}
-	lexer.NextTokenOfKind(-1) // trigger error
+	lexer.Error("syntax error")
 	panic("unreachable!")
 }
 
@@ -286,8 +303,9 @@ func _checkVar(lexer *Lexer, exp Exp) Exp {
 // namelist ::= Name {‘,’ Name}
 func parseFuncDefStat(lexer *Lexer) *AssignStat {
 	lexer.NextTokenOfKind(TOKEN_KW_FUNCTION) // function
+	start := lexer.Pos()                     //
 	fnExp, hasColon := _parseFuncName(lexer) // funcname
-	fdExp := parseFuncDefExp(lexer)          // funcbody
+	fdExp := parseFuncDefExp(lexer, start)   // funcbody
 	if hasColon {                            // insert self
 		fdExp.ParList = append(fdExp.ParList, "")
 		copy(fdExp.ParList[1:], fdExp.ParList)
@@ -295,6 +313,7 @@ func parseFuncDefStat(lexer *Lexer) *AssignStat {
 	}
 
 	return &AssignStat{
+		Span:     fdExp.Span,
 		LastLine: fdExp.Line,
 		VarList:  []Exp{fnExp},
 		ExpList:  []Exp{fdExp},
@@ -304,19 +323,19 @@ func parseFuncDefStat(lexer *Lexer) *AssignStat {
 // funcname ::= Name {‘.’ Name} [‘:’ Name]
 func _parseFuncName(lexer *Lexer) (exp Exp, hasColon bool) {
 	line, name := lexer.NextIdentifier()
-	exp = &NameExp{line, name}
+	exp = &NameExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
 
 	for lexer.LookAhead() == TOKEN_SEP_DOT {
 		lexer.NextToken()
 		line, name := lexer.NextIdentifier()
-		idx := &StringExp{line, name}
-		exp = &TableAccessExp{line, exp, idx}
+		idx := &StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
+		exp = &TableAccessExp{Span{StartPos: exp.Pos(), EndPos: lexer.End()}, line, exp, idx}
 	}
 	if lexer.LookAhead() == TOKEN_SEP_COLON {
 		lexer.NextToken()
 		line, name := lexer.NextIdentifier()
-		idx := &StringExp{line, name}
-		exp = &TableAccessExp{line, exp, idx}
+		idx := &StringExp{Span{StartPos: lexer.Pos(), EndPos: lexer.End()}, line, name}
+		exp = &TableAccessExp{Span{StartPos: exp.Pos(), EndPos: lexer.End()}, line, exp, idx}
 		hasColon = true
 	}
 
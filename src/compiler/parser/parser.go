@@ -1,13 +1,55 @@
 package parser
 
+import "io"
+import "io/ioutil"
 import . "github.com/tdkr/go-luavm/src/compiler/ast"
 import . "github.com/tdkr/go-luavm/src/compiler/lexer"
 
 /* recursive descent parser */
 
+// Parses a chunk for the code generator, folding its constant
+// expressions. Errors in the chunk are raised (as a panic)
+// as a *SyntaxError; see ParseString.
 func Parse(chunk, chunkName string) *Block {
-	lexer := NewLexer(chunk, chunkName)
+	return optimize(parse(NewLexer(chunk, chunkName)))
+}
+
+// Like Parse, but reads the chunk from r while parsing it.
+// Errors returned by r are raised as a *ReadError.
+func ParseReader(r io.Reader, chunkName string) *Block {
+	return optimize(parse(NewReaderLexer(r, chunkName)))
+}
+
+func parse(lexer *Lexer) *Block {
 	block := parseBlock(lexer)
 	lexer.NextTokenOfKind(TOKEN_EOF)
 	return block
 }
+
+// Parses a chunk into an AST that follows the source: unlike Parse,
+// it does not fold constant expressions. Errors in the chunk are
+// returned as a *SyntaxError.
+func ParseString(chunk, chunkName string) (block *Block, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r) /* not a user error */
+			}
+			block, err = nil, se
+		}
+	}()
+
+	return parse(NewLexer(chunk, chunkName)), nil
+}
+
+// Reads and parses a source file as ParseString does, using "@" and
+// its name as the chunk name like LoadFile. Errors in the chunk are
+// returned as a *SyntaxError.
+func ParseFile(filename string) (*Block, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseString(string(data), "@"+filename)
+}
@@ -0,0 +1,110 @@
+package parser
+
+import "io/ioutil"
+import "math"
+import "os"
+import "path/filepath"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/compiler/ast"
+import . "github.com/tdkr/go-luavm/src/compiler/lexer"
+
+func TestParseStringDoesNotFold(t *testing.T) {
+	chunk := "return 1 + 2, -3, not nil, true or x, 2^-1"
+	block, err := ParseString(chunk, "=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range block.RetExps {
+		switch exp.(type) {
+		case *BinopExp, *UnopExp:
+		default:
+			t.Errorf("expression %d folded to %T", i+1, exp)
+		}
+	}
+
+	block = Parse(chunk, "=t")
+	want := []Exp{&IntegerExp{Val: 3}, &IntegerExp{Val: -3},
+		&TrueExp{}, &TrueExp{}, &FloatExp{Val: 0.5}}
+	for i, exp := range block.RetExps {
+		ok := false
+		switch x := exp.(type) {
+		case *IntegerExp:
+			ok = x.Val == want[i].(*IntegerExp).Val
+		case *FloatExp:
+			ok = x.Val == want[i].(*FloatExp).Val
+		case *TrueExp:
+			_, ok = want[i].(*TrueExp)
+		}
+		if !ok {
+			t.Errorf("expression %d: got %#v, want %#v", i+1, exp, want[i])
+		}
+	}
+}
+
+func TestParseNumbers(t *testing.T) {
+	block, err := ParseString("return 1e500, 9223372036854775808, 0xffffffffffffffff", "=t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := block.RetExps[0].(*FloatExp); !ok || !math.IsInf(f.Val, 1) {
+		t.Errorf("1e500: got %#v", block.RetExps[0])
+	}
+	if f, ok := block.RetExps[1].(*FloatExp); !ok || f.Val != 9223372036854775808 {
+		t.Errorf("9223372036854775808: got %#v", block.RetExps[1])
+	}
+	if i, ok := block.RetExps[2].(*IntegerExp); !ok || i.Val != -1 {
+		t.Errorf("0xffffffffffffffff: got %#v", block.RetExps[2])
+	}
+
+	_, err = ParseString("return 1e5e", "=t")
+	if err == nil || err.Error() != "t:1: malformed number near '1e5e'" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{"x = = 1", `[string "x = = 1"]:1: unexpected symbol near '='`},
+		{"f(", `[string "f("]:1: unexpected symbol near <eof>`},
+		{"local 1", `[string "local 1"]:1: <name> expected near '1'`},
+		{"if x then", `[string "if x then"]:1: 'end' expected near <eof>`},
+		{"x = }", `[string "x = }"]:1: unexpected symbol near '}'`},
+	}
+	for _, test := range tests {
+		_, err := ParseString(test.chunk, test.chunk)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got %v, want %s", test.chunk, err, test.want)
+		}
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "bad.lua")
+	if err := ioutil.WriteFile(filename, []byte("local x = 1\n  y = = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseFile(filename)
+	se, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("got %v, want a *SyntaxError", err)
+	}
+	if want := filename + ":2: unexpected symbol near '='"; se.Error() != want {
+		t.Errorf("got %q, want %q", se.Error(), want)
+	}
+	if se.Source != "@"+filename || se.Line != 2 || se.Column != 7 {
+		t.Errorf("got %s:%d:%d", se.Source, se.Line, se.Column)
+	}
+
+	if _, err := ParseFile(filepath.Join(dir, "missing.lua")); !os.IsNotExist(err) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package lua
+
+import "fmt"
+import "reflect"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// converts the Lua value at idx to a Go value of type t
+func toValue(ls LuaState, idx int, t reflect.Type) (reflect.Value, error) {
+	if obj, ok := toObject(ls, idx); ok {
+		return convertObject(obj, t)
+	}
+	if ls.IsTable(idx) {
+		v := reflect.New(t).Elem()
+		return v, decode(ls, ls.AbsIndex(idx), v, "", 0)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if ls.IsNil(idx) {
+			return reflect.Zero(t), nil
+		}
+		if v, ok := toInterface(ls, idx); ok && v.Type().Implements(t) {
+			return v.Convert(t), nil
+		}
+	case reflect.Bool:
+		if ls.Type(idx) == LUA_TBOOLEAN {
+			return reflect.ValueOf(ls.ToBoolean(idx)).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := ls.ToIntegerX(idx); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(n) {
+				return v, fmt.Errorf("number %d out of range for %s", n, t)
+			}
+			v.SetInt(n)
+			return v, nil
+		}
+		if ls.IsNumber(idx) {
+			return reflect.Value{}, fmt.Errorf("number has no integer representation")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if n, ok := ls.ToIntegerX(idx); ok {
+			v := reflect.New(t).Elem()
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return v, fmt.Errorf("number %d out of range for %s", n, t)
+			}
+			v.SetUint(uint64(n))
+			return v, nil
+		}
+		if ls.IsNumber(idx) {
+			return reflect.Value{}, fmt.Errorf("number has no integer representation")
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := ls.ToNumberX(idx); ok {
+			return reflect.ValueOf(n).Convert(t), nil
+		}
+	case reflect.String:
+		if ls.Type(idx) == LUA_TSTRING || ls.Type(idx) == LUA_TNUMBER {
+			ls.PushValue(idx) /* ToString converts numbers in place */
+			s := ls.ToString(-1)
+			ls.Pop(1)
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if ls.IsNil(idx) {
+			return reflect.Zero(t), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%s expected, got %s", t, ls.TypeName2(idx))
+}
+
+// natural Go representation of a Lua value
+func toInterface(ls LuaState, idx int) (reflect.Value, bool) {
+	switch ls.Type(idx) {
+	case LUA_TBOOLEAN:
+		return reflect.ValueOf(ls.ToBoolean(idx)), true
+	case LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			return reflect.ValueOf(ls.ToInteger(idx)), true
+		}
+		return reflect.ValueOf(ls.ToNumber(idx)), true
+	case LUA_TSTRING:
+		return reflect.ValueOf(ls.ToString(idx)), true
+	case LUA_TUSERDATA, LUA_TLIGHTUSERDATA:
+		if p := ls.ToUserdata(idx); p != nil {
+			return reflect.ValueOf(p), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// converts a wrapped Go value, dereferencing pointers if needed
+func convertObject(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Elem().AssignableTo(t) {
+		return v.Elem(), nil
+	}
+	if v.Type().ConvertibleTo(t) && v.Kind() == t.Kind() {
+		return v.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("%s expected, got %s", t, v.Type())
+}
@@ -0,0 +1,64 @@
+package lua
+
+import "reflect"
+import . "github.com/tdkr/go-luavm/src/api"
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func pushFunc(ls LuaState, fn reflect.Value) {
+	switch f := fn.Interface().(type) {
+	case GoFunction:
+		ls.PushGoFunction(f)
+	case func(LuaState) int:
+		ls.PushGoFunction(f)
+	default:
+		ls.PushGoFunction(wrapFunc(fn))
+	}
+}
+
+// turns fn into a GoFunction converting its arguments and results
+func wrapFunc(fn reflect.Value) GoFunction {
+	t := fn.Type()
+	nIn := t.NumIn()
+	nOut := t.NumOut()
+	returnsError := nOut > 0 && t.Out(nOut-1) == errorType
+
+	return func(ls LuaState) int {
+		nArgs := ls.GetTop()
+		if t.IsVariadic() {
+			if nArgs < nIn-1 {
+				nArgs = nIn - 1
+			}
+		} else {
+			nArgs = nIn
+		}
+
+		args := make([]reflect.Value, nArgs)
+		for i := range args {
+			var argType reflect.Type
+			if t.IsVariadic() && i >= nIn-1 {
+				argType = t.In(nIn - 1).Elem()
+			} else {
+				argType = t.In(i)
+			}
+			arg, err := toValue(ls, i+1, argType)
+			if err != nil {
+				return ls.ArgError(i+1, err.Error())
+			}
+			args[i] = arg
+		}
+
+		results := fn.Call(args)
+		if returnsError {
+			if err := results[nOut-1]; !err.IsNil() {
+				return ls.ErrorE(err.Interface().(error))
+			}
+			results = results[:nOut-1]
+		}
+		ls.CheckStack2(len(results), "too many results")
+		for _, result := range results {
+			pushValue(ls, result)
+		}
+		return len(results)
+	}
+}
@@ -0,0 +1,61 @@
+package lua_test
+
+import "errors"
+import "io"
+import "math"
+import "os"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+import "github.com/tdkr/go-luavm/src/lua"
+import "github.com/tdkr/go-luavm/src/state"
+
+func TestFuncError(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	lua.SetGlobal(ls, "read", func(name string) (string, error) {
+		if name == "eof" {
+			return "", io.EOF
+		}
+		_, err := os.Open(name)
+		return "ok", err
+	})
+
+	err := ls.DoStringE("local x = 1\nreturn read('eof')")
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want a wrapped io.EOF", err)
+	}
+	if le, ok := err.(*LuaError); !ok || le.Message != `[string "local x = 1..."]:2: EOF` || le.IsGoPanic() {
+		t.Errorf("got %#v", err)
+	}
+
+	err = ls.DoStringE("read('/nonexistent/file')")
+	var pe *os.PathError
+	if !errors.As(err, &pe) || pe.Op != "open" {
+		t.Errorf("got %v, want a wrapped *os.PathError", err)
+	}
+
+	/* pcall gets the message, with a position when called from Lua */
+	if err := ls.DoStringE(`
+		local ok, msg = pcall(read, "eof")
+		assert(not ok and msg == "EOF", msg)
+		ok, msg = pcall(function() return read("eof") end)
+		assert(not ok and msg == "[string \"...\"]:4: EOF", msg)`); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestPushUint(t *testing.T) {
+	ls := state.New()
+	lua.Push(ls, uint64(math.MaxInt64))
+	lua.Push(ls, uint64(math.MaxUint64))
+	lua.Push(ls, uint(1)<<63)
+	if !ls.IsInteger(1) || ls.ToInteger(1) != math.MaxInt64 {
+		t.Errorf("MaxInt64: got %v", ls.ToNumber(1))
+	}
+	if ls.IsInteger(2) || ls.ToNumber(2) != math.MaxUint64 {
+		t.Errorf("MaxUint64: got %v", ls.ToNumber(2))
+	}
+	if ls.IsInteger(3) || ls.ToNumber(3) != 1<<63 {
+		t.Errorf("1<<63: got %v", ls.ToNumber(3))
+	}
+}
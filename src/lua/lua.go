@@ -0,0 +1,71 @@
+// Package lua moves Go values in and out of a LuaState using reflection.
+//
+// Numbers, strings and booleans are copied, unsigned integers too large
+// for a Lua integer become floats. Functions become Lua functions whose
+// arguments and results are converted automatically; a non-nil error
+// returned as the last result is raised with ErrorE, so that it stays the
+// Cause of the LuaError. Any other value (structs, pointers, slices,
+// maps...) is wrapped in a userdata that gives access to its exported
+// fields, methods, elements and keys.
+package lua
+
+import "math"
+import "reflect"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// Push converts v and pushes it onto the stack.
+func Push(ls LuaState, v interface{}) {
+	pushValue(ls, reflect.ValueOf(v))
+}
+
+// SetGlobal converts v and sets it as the new value of global name.
+func SetGlobal(ls LuaState, name string, v interface{}) {
+	Push(ls, v)
+	ls.SetGlobal(name)
+}
+
+// Func wraps an arbitrary Go function into a GoFunction.
+// It panics if fn is not a function.
+func Func(fn interface{}) GoFunction {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic("lua: Func of non-func " + v.Type().String())
+	}
+	return wrapFunc(v)
+}
+
+func pushValue(ls LuaState, v reflect.Value) {
+	if !v.IsValid() {
+		ls.PushNil()
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		ls.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ls.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			ls.PushInteger(int64(u))
+		} else { /* too large for an integer, like such numerals */
+			ls.PushNumber(float64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		ls.PushNumber(v.Float())
+	case reflect.String:
+		ls.PushString(v.String())
+	case reflect.Interface:
+		pushValue(ls, v.Elem())
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			ls.PushNil()
+		} else if v.Kind() == reflect.Func {
+			pushFunc(ls, v)
+		} else {
+			pushObject(ls, v)
+		}
+	default:
+		pushObject(ls, v)
+	}
+}
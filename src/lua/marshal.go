@@ -0,0 +1,343 @@
+package lua
+
+import "fmt"
+import "reflect"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// how deep Marshal and Unmarshal follow nested values before assuming a cycle
+const maxMarshalDepth = 1000
+
+// Error reports a value that Marshal or Unmarshal could not convert.
+// Path locates the value, e.g. "servers[2].port".
+type Error struct {
+	Path string
+	Msg  string
+}
+
+func (self *Error) Error() string {
+	if self.Path == "" {
+		return "lua: " + self.Msg
+	}
+	return "lua: " + self.Path + ": " + self.Msg
+}
+
+// Marshal pushes a plain Lua representation of v onto the stack:
+// structs and maps become tables, slices and arrays become sequences,
+// []byte becomes a string and nil pointers, slices and maps become nil.
+// Struct fields are named by their lua tags, `lua:"name,omitempty"`
+// renames a field and skips it when empty, `lua:"-"` ignores it.
+// Functions are wrapped as by Push. On error nothing is pushed.
+func Marshal(ls LuaState, v interface{}) error {
+	top := ls.GetTop()
+	if err := encode(ls, reflect.ValueOf(v), "", 0); err != nil {
+		ls.SetTop(top)
+		return err
+	}
+	return nil
+}
+
+func encode(ls LuaState, v reflect.Value, path string, depth int) error {
+	if depth > maxMarshalDepth {
+		return &Error{path, "value is too deep or cyclic"}
+	}
+	ls.CheckStack2(3, "too many nested values")
+
+	if !v.IsValid() {
+		ls.PushNil()
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64:
+		pushValue(ls, v)
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		return encode(ls, v.Elem(), path, depth+1)
+	case reflect.Func:
+		pushValue(ls, v)
+	case reflect.Slice:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			ls.PushString(string(v.Bytes()))
+			return nil
+		}
+		return encodeArray(ls, v, path, depth)
+	case reflect.Array:
+		return encodeArray(ls, v, path, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		return encodeMap(ls, v, path, depth)
+	case reflect.Struct:
+		return encodeStruct(ls, v, path, depth)
+	default:
+		return &Error{path, "unsupported type " + v.Type().String()}
+	}
+	return nil
+}
+
+func encodeArray(ls LuaState, v reflect.Value, path string, depth int) error {
+	n := v.Len()
+	ls.CreateTable(n, 0)
+	for i := 0; i < n; i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i+1)
+		if err := encode(ls, v.Index(i), elemPath, depth+1); err != nil {
+			return err
+		}
+		ls.RawSetI(-2, int64(i+1))
+	}
+	return nil
+}
+
+func encodeMap(ls LuaState, v reflect.Value, path string, depth int) error {
+	switch v.Type().Key().Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64,
+		reflect.Interface:
+	default:
+		return &Error{path, "unsupported map key type " + v.Type().Key().String()}
+	}
+
+	keys := v.MapKeys()
+	sortKeys(keys)
+	ls.CreateTable(0, len(keys))
+	for _, key := range keys {
+		elemPath := joinPath(path, fmt.Sprint(key.Interface()), key.Kind() == reflect.String)
+		if err := encode(ls, key, elemPath, depth+1); err != nil {
+			return err
+		}
+		if ls.IsNil(-1) {
+			return &Error{elemPath, "map key is nil"}
+		}
+		if err := encode(ls, v.MapIndex(key), elemPath, depth+1); err != nil {
+			return err
+		}
+		ls.RawSet(-3)
+	}
+	return nil
+}
+
+func encodeStruct(ls LuaState, v reflect.Value, path string, depth int) error {
+	fields := fieldsOf(v.Type())
+	ls.CreateTable(0, len(fields))
+	for _, f := range fields {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if err := encode(ls, fv, joinPath(path, f.name, true), depth+1); err != nil {
+			return err
+		}
+		ls.SetField(-2, f.name)
+	}
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Unmarshal stores the Lua value at idx in the Go value pointed to by v.
+// Tables are decoded into structs (keys matched against the field names
+// or lua tags, unknown keys are ignored), maps, slices and arrays;
+// into an empty interface they are decoded as []interface{} if they are
+// sequences and as map[string]interface{} or map[interface{}]interface{}
+// otherwise. Numbers are converted as by ToIntegerX and ToNumberX.
+// Nil leaves pointers, maps and slices nil and struct fields untouched.
+func Unmarshal(ls LuaState, idx int, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &Error{Msg: fmt.Sprintf("Unmarshal(non-pointer %T)", v)}
+	}
+	return decode(ls, ls.AbsIndex(idx), rv.Elem(), "", 0)
+}
+
+// decodes the value at the absolute index idx into v
+func decode(ls LuaState, idx int, v reflect.Value, path string, depth int) error {
+	if depth > maxMarshalDepth {
+		return &Error{path, "value is too deep or cyclic"}
+	}
+	_, isObject := toObject(ls, idx)
+	if isObject || !ls.IsTable(idx) {
+		if v.Kind() == reflect.Ptr && !isObject && !ls.IsNil(idx) {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			return decode(ls, idx, v.Elem(), path, depth+1)
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 &&
+			ls.Type(idx) == LUA_TSTRING {
+			v.SetBytes([]byte(ls.ToString(idx)))
+			return nil
+		}
+		val, err := toValue(ls, idx, v.Type())
+		if err != nil {
+			return &Error{path, err.Error()}
+		}
+		v.Set(val)
+		return nil
+	}
+
+	ls.CheckStack2(3, "too many nested tables")
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(ls, idx, v.Elem(), path, depth+1)
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			return decodeAny(ls, idx, v, path, depth)
+		}
+	case reflect.Struct:
+		return decodeStruct(ls, idx, v, path, depth)
+	case reflect.Map:
+		return decodeMap(ls, idx, v, path, depth)
+	case reflect.Slice:
+		n := int(ls.RawLen(idx))
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		return decodeArray(ls, idx, v, path, depth)
+	case reflect.Array:
+		return decodeArray(ls, idx, v, path, depth)
+	}
+	return &Error{path, fmt.Sprintf("%s expected, got table", v.Type())}
+}
+
+func decodeStruct(ls LuaState, idx int, v reflect.Value, path string, depth int) error {
+	for _, f := range fieldsOf(v.Type()) {
+		if ls.GetField(idx, f.name) != LUA_TNIL {
+			fieldPath := joinPath(path, f.name, true)
+			if err := decode(ls, ls.AbsIndex(-1), v.FieldByIndex(f.index), fieldPath, depth+1); err != nil {
+				ls.Pop(1)
+				return err
+			}
+		}
+		ls.Pop(1)
+	}
+	return nil
+}
+
+func decodeMap(ls LuaState, idx int, v reflect.Value, path string, depth int) error {
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	ls.PushNil()
+	for ls.Next(idx) {
+		elemPath := keyPath(ls, path, -2)
+		key := reflect.New(t.Key()).Elem()
+		if err := decode(ls, ls.AbsIndex(-2), key, elemPath, depth+1); err != nil {
+			ls.Pop(2)
+			return err
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := decode(ls, ls.AbsIndex(-1), elem, elemPath, depth+1); err != nil {
+			ls.Pop(2)
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		ls.Pop(1)
+	}
+	return nil
+}
+
+// fills v from the sequence at idx, extra elements are ignored
+func decodeArray(ls LuaState, idx int, v reflect.Value, path string, depth int) error {
+	n := int(ls.RawLen(idx))
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		if i >= n {
+			elem.Set(reflect.Zero(elem.Type()))
+			continue
+		}
+		ls.RawGetI(idx, int64(i+1))
+		err := decode(ls, ls.AbsIndex(-1), elem, fmt.Sprintf("%s[%d]", path, i+1), depth+1)
+		ls.Pop(1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sequences become []interface{}, other tables maps
+func decodeAny(ls LuaState, idx int, v reflect.Value, path string, depth int) error {
+	n, nKeys, stringKeys := int(ls.RawLen(idx)), 0, true
+	ls.PushNil()
+	for ls.Next(idx) {
+		nKeys++
+		stringKeys = stringKeys && ls.Type(-2) == LUA_TSTRING
+		ls.Pop(1)
+	}
+
+	var val reflect.Value
+	if n > 0 && nKeys == n {
+		val = reflect.ValueOf(make([]interface{}, n))
+		if err := decodeArray(ls, idx, val, path, depth); err != nil {
+			return err
+		}
+	} else {
+		if stringKeys {
+			val = reflect.ValueOf(map[string]interface{}{})
+		} else {
+			val = reflect.ValueOf(map[interface{}]interface{}{})
+		}
+		if err := decodeMap(ls, idx, val, path, depth); err != nil {
+			return err
+		}
+	}
+	v.Set(val)
+	return nil
+}
+
+// path of the table element whose key is at idx
+func keyPath(ls LuaState, path string, idx int) string {
+	switch ls.Type(idx) {
+	case LUA_TSTRING:
+		return joinPath(path, ls.ToString(idx), true)
+	case LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			return fmt.Sprintf("%s[%d]", path, ls.ToInteger(idx))
+		}
+		return fmt.Sprintf("%s[%g]", path, ls.ToNumber(idx))
+	default:
+		return fmt.Sprintf("%s[%s]", path, ls.TypeName2(idx))
+	}
+}
+
+func joinPath(path, key string, isString bool) string {
+	if !isString {
+		return path + "[" + key + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
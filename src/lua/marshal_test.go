@@ -0,0 +1,157 @@
+package lua_test
+
+import "math"
+import "reflect"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+import "github.com/tdkr/go-luavm/src/lua"
+import "github.com/tdkr/go-luavm/src/state"
+
+type server struct {
+	Host  string
+	Port  int `lua:"port"`
+	Tags  []string
+	Extra map[string]int `lua:",omitempty"`
+	Skip  bool           `lua:"-"`
+}
+
+type node struct {
+	V    int
+	Next *node
+}
+
+// runs chunk and leaves its single result on the stack
+func eval(t *testing.T, chunk string) LuaState {
+	ls := state.New()
+	ls.OpenLibs()
+	if err := ls.DoStringE("return " + chunk); err != nil {
+		t.Fatal(err)
+	}
+	return ls
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := []server{
+		{Host: "a", Port: 80, Tags: []string{"x", "y"}},
+		{Host: "b", Port: 443, Extra: map[string]int{"k": 1}},
+	}
+	ls := state.New()
+	if err := lua.Marshal(ls, in); err != nil {
+		t.Fatal(err)
+	}
+	var out []server
+	if err := lua.Unmarshal(ls, -1, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %#v, want %#v", out, in)
+	}
+	if ls.GetTop() != 1 {
+		t.Errorf("stack has %d values, want 1", ls.GetTop())
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	ls := eval(t, `{Host = "h", port = 8080, Tags = {"a", "b"}, unknown = 1}`)
+	var s server
+	if err := lua.Unmarshal(ls, -1, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := server{Host: "h", Port: 8080, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %#v, want %#v", s, want)
+	}
+
+	ls = eval(t, `{1, "two", {x = 3}, {4}}`)
+	var v interface{}
+	if err := lua.Unmarshal(ls, -1, &v); err != nil {
+		t.Fatal(err)
+	}
+	wantAny := []interface{}{int64(1), "two",
+		map[string]interface{}{"x": int64(3)}, []interface{}{int64(4)}}
+	if !reflect.DeepEqual(v, wantAny) {
+		t.Errorf("got %#v, want %#v", v, wantAny)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		v     interface{}
+		want  string
+	}{
+		{`{port = "x"}`, &server{}, "lua: port: int expected, got string"},
+		{`{Tags = {1, {}}}`, &server{}, "lua: Tags[2]: string expected, got table"},
+		{`42`, server{}, "lua: Unmarshal(non-pointer lua_test.server)"},
+	}
+	for _, test := range tests {
+		ls := eval(t, test.chunk)
+		err := lua.Unmarshal(ls, -1, test.v)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.chunk, err, test.want)
+		}
+	}
+}
+
+func TestUnmarshalCycle(t *testing.T) {
+	tests := []struct {
+		chunk string
+		v     interface{}
+	}{
+		{`(function() local n = {V = 1} n.Next = n return n end)()`, &node{}},
+		{`(function() local u = {} u[1] = u return u end)()`, new(interface{})},
+		{`(function() local m = {} m.m = m return m end)()`, new(map[string]interface{})},
+	}
+	for _, test := range tests {
+		ls := eval(t, test.chunk)
+		err := lua.Unmarshal(ls, -1, test.v)
+		if e, ok := err.(*lua.Error); !ok || e.Msg != "value is too deep or cyclic" || e.Path == "" {
+			t.Errorf("%s: got %v", test.chunk, err)
+		}
+		if ls.GetTop() != 1 {
+			t.Errorf("%s: stack has %d values, want 1", test.chunk, ls.GetTop())
+		}
+	}
+
+	/* deep but finite nesting is fine */
+	ls := eval(t, `(function() local n = nil for i = 1, 100 do n = {V = i, Next = n} end return n end)()`)
+	var n node
+	if err := lua.Unmarshal(ls, -1, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.V != 100 || n.Next.V != 99 {
+		t.Errorf("got %d, %d", n.V, n.Next.V)
+	}
+}
+
+func TestMarshalCycle(t *testing.T) {
+	n := &node{V: 1}
+	n.Next = n
+	ls := state.New()
+	err := lua.Marshal(ls, n)
+	if e, ok := err.(*lua.Error); !ok || e.Msg != "value is too deep or cyclic" {
+		t.Errorf("got %v", err)
+	}
+	if ls.GetTop() != 0 {
+		t.Errorf("stack has %d values, want 0", ls.GetTop())
+	}
+}
+
+func TestMarshalUint(t *testing.T) {
+	type counters struct {
+		Small uint64
+		Large uint64
+	}
+	ls := state.New()
+	if err := lua.Marshal(ls, counters{42, math.MaxUint64}); err != nil {
+		t.Fatal(err)
+	}
+	ls.GetField(-1, "Small")
+	ls.GetField(-2, "Large")
+	if !ls.IsInteger(-2) || ls.ToInteger(-2) != 42 {
+		t.Errorf("Small: got %v", ls.ToNumber(-2))
+	}
+	if ls.IsInteger(-1) || ls.ToNumber(-1) != math.MaxUint64 {
+		t.Errorf("Large: got %v, want a float", ls.ToNumber(-1))
+	}
+}
@@ -0,0 +1,345 @@
+package lua
+
+import "fmt"
+import "reflect"
+import "sort"
+import "strings"
+import "sync"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// name of the metatable shared by all wrapped Go values
+const objectMeta = "GoObject"
+
+var objectFuncs map[string]GoFunction
+
+func init() {
+	objectFuncs = map[string]GoFunction{
+		"__index":    objIndex,
+		"__newindex": objNewIndex,
+		"__len":      objLen,
+		"__pairs":    objPairs,
+		"__eq":       objEq,
+		"__tostring": objToString,
+	}
+}
+
+// wraps v in a userdata
+func pushObject(ls LuaState, v reflect.Value) {
+	ls.NewUserdata(v.Interface())
+	if ls.NewMetatable(objectMeta) {
+		ls.SetFuncs(objectFuncs, 0)
+	}
+	ls.SetMetatable(-2)
+}
+
+// value wrapped by the userdata at idx
+func toObject(ls LuaState, idx int) (reflect.Value, bool) {
+	if data := ls.TestUdata(idx, objectMeta); data != nil {
+		return reflect.ValueOf(data), true
+	}
+	return reflect.Value{}, false
+}
+
+func checkObject(ls LuaState, arg int) reflect.Value {
+	return reflect.ValueOf(ls.CheckUdata(arg, objectMeta))
+}
+
+// the value whose elements or fields are accessed:
+// pointers to structs and arrays are followed
+func indirect(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		switch v.Elem().Kind() {
+		case reflect.Struct, reflect.Array:
+			return v.Elem()
+		}
+	}
+	return v
+}
+
+// pushes an element; addressable structs and arrays are pushed
+// as pointers so that changes through them are seen by Go
+func pushElem(ls LuaState, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Array:
+		if v.CanAddr() {
+			v = v.Addr()
+		}
+	}
+	pushValue(ls, v)
+}
+
+// __index(obj, key): methods first, then fields, elements or map keys
+func objIndex(ls LuaState) int {
+	obj := checkObject(ls, 1)
+	if name, ok := toName(ls, 2); ok {
+		if method, ok := obj.Type().MethodByName(name); ok {
+			pushFunc(ls, method.Func)
+			return 1
+		}
+	}
+
+	v := indirect(obj)
+	switch v.Kind() {
+	case reflect.Struct:
+		if name, ok := toName(ls, 2); ok {
+			if f, ok := fieldByName(v, name); ok {
+				pushElem(ls, f)
+				return 1
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.String:
+		if i, ok := ls.ToIntegerX(2); ok {
+			if i >= 1 && i <= int64(v.Len()) {
+				pushElem(ls, v.Index(int(i-1)))
+				return 1
+			}
+		}
+	case reflect.Map:
+		if key, err := toValue(ls, 2, v.Type().Key()); err == nil {
+			if elem := v.MapIndex(key); elem.IsValid() {
+				pushValue(ls, elem)
+				return 1
+			}
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+// __newindex(obj, key, val)
+func objNewIndex(ls LuaState) int {
+	v := indirect(checkObject(ls, 1))
+	switch v.Kind() {
+	case reflect.Struct:
+		name, _ := toName(ls, 2)
+		f, ok := fieldByName(v, name)
+		if !ok {
+			return ls.Error2("%s has no field '%s'", v.Type(), ls.ToString2(2))
+		}
+		if !f.CanSet() {
+			return ls.Error2("cannot assign to field '%s' of a %s value", name, v.Type())
+		}
+		setValue(ls, f, 3, "field '"+name+"'")
+	case reflect.Slice, reflect.Array:
+		i, ok := ls.ToIntegerX(2)
+		if !ok || i < 1 || i > int64(v.Len()) {
+			return ls.Error2("index out of range")
+		}
+		elem := v.Index(int(i - 1))
+		if !elem.CanSet() {
+			return ls.Error2("cannot assign to an element of a %s value", v.Type())
+		}
+		setValue(ls, elem, 3, "element")
+	case reflect.Map:
+		key, err := toValue(ls, 2, v.Type().Key())
+		if err != nil {
+			return ls.Error2("invalid map key: %s", err.Error())
+		}
+		if ls.IsNil(3) {
+			v.SetMapIndex(key, reflect.Value{})
+		} else {
+			elem, err := toValue(ls, 3, v.Type().Elem())
+			if err != nil {
+				return ls.Error2("invalid map value: %s", err.Error())
+			}
+			v.SetMapIndex(key, elem)
+		}
+	default:
+		return ls.Error2("cannot assign to a %s value", v.Type())
+	}
+	return 0
+}
+
+func setValue(ls LuaState, dst reflect.Value, idx int, what string) {
+	val, err := toValue(ls, idx, dst.Type())
+	if err != nil {
+		ls.Error2("invalid value for %s: %s", what, err.Error())
+	}
+	dst.Set(val)
+}
+
+// __len(obj)
+func objLen(ls LuaState) int {
+	v := indirect(checkObject(ls, 1))
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String, reflect.Chan:
+		ls.PushInteger(int64(v.Len()))
+		return 1
+	}
+	return ls.Error2("attempt to get length of a %s value", v.Type())
+}
+
+// __pairs(obj): iterates over the elements of slices and arrays,
+// the keys of maps (in sorted order when possible) or the fields of structs
+func objPairs(ls LuaState) int {
+	v := indirect(checkObject(ls, 1))
+	var keys []reflect.Value
+	var get func(key reflect.Value) reflect.Value
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		n := v.Len()
+		i := 0
+		ls.PushGoFunction(func(ls LuaState) int {
+			if i >= n || i >= v.Len() {
+				return 0
+			}
+			i++
+			ls.PushInteger(int64(i))
+			pushElem(ls, v.Index(i-1))
+			return 2
+		})
+		ls.PushValue(1)
+		ls.PushNil()
+		return 3
+	case reflect.Map:
+		keys = v.MapKeys()
+		sortKeys(keys)
+		get = v.MapIndex
+	case reflect.Struct:
+		for _, f := range fieldsOf(v.Type()) {
+			keys = append(keys, reflect.ValueOf(f.name))
+		}
+		get = func(key reflect.Value) reflect.Value {
+			f, _ := fieldByName(v, key.String())
+			return f
+		}
+	default:
+		return ls.Error2("cannot iterate over a %s value", v.Type())
+	}
+
+	i := 0
+	ls.PushGoFunction(func(ls LuaState) int {
+		for i < len(keys) {
+			key := keys[i]
+			i++
+			if val := get(key); val.IsValid() {
+				pushValue(ls, key)
+				pushElem(ls, val)
+				return 2
+			}
+		}
+		return 0
+	})
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+func sortKeys(keys []reflect.Value) {
+	if len(keys) == 0 {
+		return
+	}
+	switch keys[0].Kind() {
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	case reflect.Float32, reflect.Float64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Float() < keys[j].Float() })
+	}
+}
+
+// __eq(a, b)
+func objEq(ls LuaState) int {
+	a, ok1 := toObject(ls, 1)
+	b, ok2 := toObject(ls, 2)
+	ls.PushBoolean(ok1 && ok2 && a.Type() == b.Type() &&
+		a.Type().Comparable() && a.Interface() == b.Interface())
+	return 1
+}
+
+// __tostring(obj)
+func objToString(ls LuaState) int {
+	v := checkObject(ls, 1)
+	switch x := v.Interface().(type) {
+	case fmt.Stringer:
+		ls.PushString(x.String())
+	case error:
+		ls.PushString(x.Error())
+	default:
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan:
+			ls.PushFString("%s: %p", v.Type(), x)
+		default:
+			ls.PushFString("%s", v.Type())
+		}
+	}
+	return 1
+}
+
+func toName(ls LuaState, idx int) (string, bool) {
+	if ls.Type(idx) == LUA_TSTRING {
+		return ls.ToString(idx), true
+	}
+	return "", false
+}
+
+/* struct fields */
+
+type field struct {
+	name      string // Go name, or the name given by the lua tag
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+// exported fields of a struct type, including the promoted fields
+// of embedded structs, named and configured by their lua tags
+func fieldsOf(t reflect.Type) []field {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]field)
+	}
+	var fields []field
+	seen := map[string]bool{}
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		var embedded [][]int
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			idx := append(append([]int(nil), index...), i)
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				embedded = append(embedded, idx)
+				continue
+			}
+			if sf.PkgPath != "" { /* unexported */
+				continue
+			}
+			f := field{name: sf.Name, index: idx}
+			if tag := sf.Tag.Get("lua"); tag != "" {
+				opts := strings.Split(tag, ",")
+				if opts[0] == "-" {
+					continue
+				} else if opts[0] != "" {
+					f.name = opts[0]
+				}
+				for _, opt := range opts[1:] {
+					f.omitEmpty = f.omitEmpty || opt == "omitempty"
+				}
+			}
+			if !seen[f.name] {
+				seen[f.name] = true
+				fields = append(fields, f)
+			}
+		}
+		for _, idx := range embedded {
+			collect(t.FieldByIndex(idx[len(idx)-1:]).Type, idx)
+		}
+	}
+	collect(t, nil)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func fieldByName(v reflect.Value, name string) (reflect.Value, bool) {
+	for _, f := range fieldsOf(v.Type()) {
+		if f.name == name {
+			return v.FieldByIndex(f.index), true
+		}
+	}
+	return reflect.Value{}, false
+}
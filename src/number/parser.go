@@ -57,6 +57,9 @@ func ParseFloat(str string) (float64, bool) {
 		return -f, ok
 	}
 	f, err := strconv.ParseFloat(str, 64)
+	if err != nil && err.(*strconv.NumError).Err == strconv.ErrRange {
+		return f, true /* overflows to ±Inf, as strtod() does */
+	}
 	return f, err == nil
 }
 
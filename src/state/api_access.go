@@ -94,6 +94,19 @@ func (self *luaState) IsThread(idx int) bool {
 	return self.Type(idx) == LUA_TTHREAD
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_isuserdata
+func (self *luaState) IsUserdata(idx int) bool {
+	t := self.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_islightuserdata
+func (self *luaState) IsLightUserdata(idx int) bool {
+	return self.Type(idx) == LUA_TLIGHTUSERDATA
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isstring
 func (self *luaState) IsString(idx int) bool {
@@ -205,9 +218,25 @@ func (self *luaState) ToThread(idx int) LuaState {
 	return nil
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_touserdata
+func (self *luaState) ToUserdata(idx int) interface{} {
+	switch x := self.stack.get(idx).(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return x.ptr
+	default:
+		return nil
+	}
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_topointer
 func (self *luaState) ToPointer(idx int) interface{} {
-	// todo
-	return self.stack.get(idx)
+	val := self.stack.get(idx)
+	if x, ok := val.(lightUserdata); ok {
+		return x.ptr
+	}
+	return val
 }
@@ -61,6 +61,12 @@ func (self *luaState) Arith(op ArithOp) {
 		a = b
 	}
 
+	if y, ok := b.(int64); ok && y == 0 && (op == LUA_OPIDIV || op == LUA_OPMOD) {
+		if _, ok := a.(int64); ok { /* integer division by zero? */
+			self.divByZeroError(op)
+		}
+	}
+
 	operator := operators[op]
 	if result := _arith(a, b, operator); result != nil {
 		self.stack.push(result)
@@ -73,7 +79,23 @@ func (self *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	if operator.floatFunc != nil {
+		self.opIntError(a, b, "perform arithmetic on")
+	} else if _, ok := convertToFloat(a); ok { /* both operands are numbers? */
+		if _, ok := convertToFloat(b); ok {
+			self.toIntError(a, b) /* error message for integers */
+		}
+	}
+	self.opIntError(a, b, "perform bitwise operation on")
+}
+
+// lua-5.3.4/src/lvm.c#luaV_div()
+// lua-5.3.4/src/lvm.c#luaV_mod()
+func (self *luaState) divByZeroError(op ArithOp) {
+	if op == LUA_OPIDIV {
+		self.runError("attempt to perform 'n//0'")
+	}
+	self.runError("attempt to perform 'n%%0'")
 }
 
 func _arith(a, b luaValue, op operator) luaValue {
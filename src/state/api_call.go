@@ -1,32 +1,157 @@
 package state
 
+import "bufio"
+import "bytes"
+import "fmt"
+import "io"
+import "strings"
 import . "github.com/tdkr/go-luavm/src/api"
 import "github.com/tdkr/go-luavm/src/binchunk"
 import "github.com/tdkr/go-luavm/src/compiler"
+import "github.com/tdkr/go-luavm/src/compiler/lexer"
 import "github.com/tdkr/go-luavm/src/vm"
 
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_load
 func (self *luaState) Load(chunk []byte, chunkName, mode string) int {
-	var proto *binchunk.Prototype
 	if binchunk.IsBinaryChunk(chunk) {
-		proto = binchunk.Undump(chunk)
-	} else {
-		proto = compiler.Compile(string(chunk), chunkName)
+		return self.loadBinary(bytes.NewReader(chunk), chunkName, mode)
+	}
+	return self.loadText(string(chunk), chunkName, mode)
+}
+
+// [-0, +1, –]
+// Like Load, but reads the chunk from r. Text chunks are compiled and
+// binary chunks undumped as they are read, so the whole chunk is never
+// held in memory. Errors returned by r give LUA_ERRFILE.
+func (self *luaState) LoadReader(r io.Reader, chunkName, mode string) int {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(binchunk.LUA_SIGNATURE) + 1); binchunk.IsBinaryChunk(head) {
+		return self.loadBinary(br, chunkName, mode)
+	}
+	if !self.checkMode(mode, "text", 't') {
+		return LUA_ERRSYNTAX
+	}
+	proto, err := compiler.CompileReader(br, chunkName)
+	if err != nil {
+		self.PushString(err.Error())
+		if _, ok := err.(*compiler.ReadError); ok {
+			return LUA_ERRFILE
+		}
+		return LUA_ERRSYNTAX
+	}
+	self.pushMainClosure(proto)
+	return LUA_OK
+}
+
+func (self *luaState) loadText(chunk, chunkName, mode string) int {
+	if !self.checkMode(mode, "text", 't') {
+		return LUA_ERRSYNTAX
 	}
+	proto, err := compiler.Compile(chunk, chunkName)
+	if err != nil {
+		self.PushString(err.Error())
+		return LUA_ERRSYNTAX
+	}
+	self.pushMainClosure(proto)
+	return LUA_OK
+}
+
+func (self *luaState) loadBinary(r io.Reader, chunkName, mode string) int {
+	if !self.checkMode(mode, "binary", 'b') {
+		return LUA_ERRSYNTAX
+	}
+	proto, err := binchunk.UndumpReader(r)
+	if err != nil {
+		if _, ok := err.(*binchunk.FormatError); ok {
+			self.PushFString("%s: %s", lexer.ChunkID(chunkName), err)
+			return LUA_ERRSYNTAX
+		}
+		self.PushString(err.Error())
+		return LUA_ERRFILE
+	}
+	self.pushMainClosure(proto)
+	return LUA_OK
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+// Returns nil if the value on the top of the stack is not a Lua function.
+func (self *luaState) Dump(strip bool) []byte {
+	if c, ok := self.stack.get(-1).(*closure); ok && c.proto != nil {
+		return binchunk.Dump(c.proto, strip)
+	}
+	return nil
+}
 
+// the first upvalue of a main chunk is _ENV, the others (of a dumped
+// function) start as nil
+// lua-5.3.4/src/lfunc.c#luaF_initupvals()
+func (self *luaState) pushMainClosure(proto *binchunk.Prototype) {
 	c := newLuaClosure(proto)
 	self.stack.push(c)
-	if len(proto.Upvalues) > 0 {
-		env := self.registry.get(LUA_RIDX_GLOBALS)
-		c.upvals[0] = &upvalue{&env}
+	for i := range c.upvals {
+		var val luaValue
+		c.upvals[i] = &upvalue{&val}
 	}
-	return LUA_OK
+	if len(c.upvals) > 0 {
+		*c.upvals[0].val = self.registry.get(LUA_RIDX_GLOBALS)
+	}
+}
+
+// lua-5.3.4/src/ldo.c#checkmode()
+// the sandbox load mode, if any, restricts mode further
+func (self *luaState) checkMode(mode, x string, c byte) bool {
+	for _, m := range []string{mode, self.g.loadMode} {
+		if m != "" && strings.IndexByte(m, c) < 0 {
+			self.PushFString("attempt to load a %s chunk (mode is '%s')", x, m)
+			return false
+		}
+	}
+	return true
 }
 
 // [-(nargs+1), +nresults, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_call
 func (self *luaState) Call(nArgs, nResults int) {
+	self.CallK(nArgs, nResults, 0, nil)
+}
+
+// [-(nargs + 1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_callk
+// lua-5.3.4/src/lapi.c#lua_callk()
+func (self *luaState) CallK(nArgs, nResults int, ctx KContext, k KFunction) {
+	stack := self.stack
+	if stack.closure != nil && stack.closure.proto != nil {
+		self.call(nArgs, nResults) /* called by the VM, may yield */
+	} else if k != nil && self.nny == 0 { /* need to prepare continuation? */
+		stack.k = k
+		stack.ctx = ctx
+		self.call(nArgs, nResults)
+	} else { /* no continuation or not yieldable */
+		self.callNoYield(nArgs, nResults)
+	}
+}
+
+// lua-5.3.4/src/ldo.c#luaD_callnoyield()
+func (self *luaState) callNoYield(nArgs, nResults int) {
+	self.nny++
+	defer func() { self.nny-- }() /* also when an error unwinds */
+	self.call(nArgs, nResults)
+}
+
+// metamethods called by the VM may yield, those
+// called by Go functions may not
+// lua-5.3.4/src/ltm.c#luaT_callTM()
+func (self *luaState) callTM(nArgs, nResults int) {
+	if c := self.stack.closure; c != nil && c.proto != nil { /* Lua code? */
+		self.call(nArgs, nResults)
+	} else {
+		self.callNoYield(nArgs, nResults)
+	}
+}
+
+func (self *luaState) call(nArgs, nResults int) {
 	val := self.stack.get(-(nArgs + 1))
 
 	c, ok := val.(*closure)
@@ -47,7 +172,7 @@ func (self *luaState) Call(nArgs, nResults int) {
 			self.callGoClosure(nArgs, nResults, c)
 		}
 	} else {
-		panic("not function!")
+		self.valueTypeError(val, "call")
 	}
 }
 
@@ -55,6 +180,7 @@ func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	// create new lua stack
 	newStack := newLuaStack(nArgs+LUA_MINSTACK, self)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	// pass args, pop func
 	if nArgs > 0 {
@@ -65,15 +191,11 @@ func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 
 	// run closure
 	self.pushLuaStack(newStack)
-	r := c.goFunc(self)
-	self.popLuaStack()
-
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(r)
-		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
+	if self.hookMask&LUA_MASKCALL != 0 {
+		self.callHook(LUA_HOOKCALL, -1)
 	}
+	r := c.goFunc(self)
+	self.postCall(r)
 }
 
 func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
@@ -84,6 +206,7 @@ func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 	// create new lua stack
 	newStack := newLuaStack(nRegs+LUA_MINSTACK, self)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	// pass args, pop func
 	funcAndArgs := self.stack.popN(nArgs + 1)
@@ -95,20 +218,22 @@ func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 
 	// run closure
 	self.pushLuaStack(newStack)
-	self.runLuaClosure()
-	self.popLuaStack()
-
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
+	if self.hookMask&LUA_MASKCALL != 0 {
+		newStack.pc++ /* hooks assume 'pc' is already incremented */
+		self.callHook(LUA_HOOKCALL, -1)
+		newStack.pc--
 	}
+	self.runLuaClosure()
+	self.postCall(newStack.top - nRegs)
 }
 
 func (self *luaState) runLuaClosure() {
 	for {
+		self.step()
 		inst := vm.Instruction(self.Fetch())
+		if self.hookMask&(LUA_MASKLINE|LUA_MASKCOUNT) != 0 {
+			self.traceExec()
+		}
 		inst.Execute(self)
 		if inst.Opcode() == vm.OP_RETURN {
 			break
@@ -116,26 +241,168 @@ func (self *luaState) runLuaClosure() {
 	}
 }
 
+// pops the running function, moving its last nRet
+// values to the caller as the results of the call
+// lua-5.3.4/src/ldo.c#luaD_poscall()
+func (self *luaState) postCall(nRet int) {
+	stack := self.stack
+	if self.hookMask&(LUA_MASKRET|LUA_MASKLINE) != 0 && !stack.isHook {
+		if self.hookMask&LUA_MASKRET != 0 {
+			self.callHook(LUA_HOOKRET, -1)
+		}
+		if caller := stack.prev; caller.closure != nil && caller.closure.proto != nil {
+			self.oldPC = caller.pc - 1 /* 'oldPC' for caller function */
+		}
+	}
+	self.popLuaStack()
+	if stack.nResults != 0 {
+		results := stack.popN(nRet)
+		self.stack.check(len(results))
+		self.stack.pushN(results, stack.nResults)
+	}
+}
+
 // Calls a function in protected mode.
 // http://www.lua.org/manual/5.3/manual.html#lua_pcall
-func (self *luaState) PCall(nArgs, nResults, msgh int) (status int) {
+func (self *luaState) PCall(nArgs, nResults, msgh int) int {
+	if err := self.pcall(nArgs, nResults, msgh, false); err != nil {
+		self.stack.push(err.Value)
+		return err.Status
+	}
+	return LUA_OK
+}
+
+// [-(nargs + 1), +(nresults|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pcallk
+// lua-5.3.4/src/lapi.c#lua_pcallk()
+func (self *luaState) PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int {
+	if k == nil || self.nny > 0 { /* no continuation or no yieldable? */
+		return self.PCall(nArgs, nResults, msgh)
+	}
+
+	/* prepare continuation (call is already protected by 'resume') */
+	stack := self.stack
+	stack.k = k
+	stack.ctx = ctx
+	stack.pcall = &pcallInfo{oldTop: stack.top - (nArgs + 1)}
+	if msgh != 0 {
+		stack.pcall.handler = stack.get(msgh)
+	}
+	err := self.pcall(nArgs, nResults, msgh, false)
+	stack.pcall = nil
+	if err != nil {
+		self.stack.push(err.Value)
+		return err.Status
+	}
+	return LUA_OK
+}
+
+// [-(nargs+1), +(nresults|0), –]
+// Like PCall, but on failure nothing is left on the stack and the
+// error is returned as a *LuaError carrying a traceback.
+func (self *luaState) PCallE(nArgs, nResults int) error {
+	if err := self.pcall(nArgs, nResults, 0, true); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (self *luaState) pcall(nArgs, nResults, msgh int, traceback bool) (err *LuaError) {
 	caller := self.stack
-	status = LUA_ERRRUN
+	oldTop := caller.top - (nArgs + 1) // slot of the called function
+	oldNny := self.nny
+	var handler luaValue
+	if msgh != 0 {
+		handler = caller.get(msgh)
+	}
 
 	// catch error
 	defer func() {
-		if err := recover(); err != nil {
-			if msgh != 0 {
-				panic(err)
+		if r := recover(); r != nil {
+			if _, ok := r.(coYield); ok { /* not an error */
+				panic(r)
+			}
+			err = self.newLuaError(r, traceback)
+			if handler != nil && err.Status == LUA_ERRRUN {
+				self.callMsgHandler(handler, err)
 			}
 			for self.stack != caller {
 				self.popLuaStack()
 			}
-			self.stack.push(err)
+			self.SetTop(oldTop)
+			self.nny = oldNny
 		}
 	}()
 
-	self.Call(nArgs, nResults)
-	status = LUA_OK
-	return
+	if caller.pcall != nil { /* called by PCallK */
+		self.call(nArgs, nResults)
+	} else {
+		self.callNoYield(nArgs, nResults)
+	}
+	return nil
+}
+
+// converts a recovered value, must be called before the stack is unwound
+func (self *luaState) newLuaError(r interface{}, traceback bool) *LuaError {
+	err := &LuaError{
+		Status:   LUA_ERRRUN,
+		Position: self.position(),
+	}
+	switch x := r.(type) {
+	case nilError:
+		err.Value = nil
+	case *goError:
+		err.Value = x.msg
+		err.Cause = x.err
+	case *interruptError:
+		err.Value = x.Error()
+		err.Cause = x.cause
+	case *memoryError:
+		err.Status = LUA_ERRMEM
+		err.Value = x.Error()
+		err.Cause = ErrMemoryLimit
+	default:
+		if isLuaValue(r) {
+			err.Value = r
+		} else { /* Go runtime panic */
+			err.Value = fmt.Sprint(r)
+			err.GoPanic = r
+		}
+	}
+	err.Message = self.errorMessage(err.Value)
+	if traceback {
+		err.Traceback = self.traceback()
+	}
+	return err
+}
+
+// calls the message handler where the error happened, so that it can
+// still inspect the stack; an error inside the handler gives LUA_ERRERR
+func (self *luaState) callMsgHandler(handler luaValue, err *LuaError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err.Status = LUA_ERRERR
+			err.Value = "error in error handling"
+			err.Message = "error in error handling"
+		}
+	}()
+
+	self.stack.check(2)
+	self.stack.push(handler)
+	self.stack.push(err.Value)
+	self.callNoYield(1, 1)
+	err.Value = self.stack.pop()
+	err.Message = self.errorMessage(err.Value)
+}
+
+func (self *luaState) errorMessage(val luaValue) string {
+	switch x := val.(type) {
+	case string:
+		return x
+	case int64, float64:
+		return fmt.Sprintf("%v", x)
+	default:
+		return fmt.Sprintf("(error object is a %s value)",
+			self.TypeName(typeOf(x)))
+	}
 }
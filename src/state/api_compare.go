@@ -70,6 +70,13 @@ func _eq(a, b luaValue, ls *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata:
+		if y, ok := b.(*userdata); ok && x != y && ls != nil {
+			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -100,7 +107,8 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
 	}
 }
 
@@ -128,9 +136,13 @@ func _le(a, b luaValue, ls *luaState) bool {
 
 	if result, ok := callMetamethod(a, b, "__le", ls); ok {
 		return convertToBoolean(result)
-	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
+	}
+	ls.stack.leq = true /* mark it is doing 'lt' for 'le' */
+	result, ok := callMetamethod(b, a, "__lt", ls)
+	ls.stack.leq = false
+	if ok {
 		return !convertToBoolean(result)
-	} else {
-		panic("comparison error!")
 	}
+	ls.orderError(a, b)
+	return false
 }
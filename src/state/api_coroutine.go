@@ -1,12 +1,27 @@
 package state
 
 import . "github.com/tdkr/go-luavm/src/api"
+import "github.com/tdkr/go-luavm/src/vm"
+
+// panicked by YieldK, unwinds the Go stack of the coroutine up to Resume
+type coYield struct{}
+
+// kept in the frame of a Go function that called PCallK, in case
+// an error is raised after the coroutine was suspended and resumed
+type pcallInfo struct {
+	oldTop  int      /* slot of the called function */
+	handler luaValue /* message handler */
+}
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_newthread
 // lua-5.3.4/src/lstate.c#lua_newthread()
 func (self *luaState) NewThread() LuaState {
-	t := &luaState{registry: self.registry}
+	t := &luaState{g: self.g, registry: self.registry, nny: 1}
+	t.hook = self.hook /* new thread inherits hooks */
+	t.hookMask = self.hookMask
+	t.baseHookCount = self.baseHookCount
+	t.hookCount = self.baseHookCount
 	t.pushLuaStack(newLuaStack(LUA_MINSTACK, t))
 	self.stack.push(t)
 	return t
@@ -14,63 +29,203 @@ func (self *luaState) NewThread() LuaState {
 
 // [-?, +?, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_resume
+// lua-5.3.4/src/ldo.c#lua_resume()
 func (self *luaState) Resume(from LuaState, nArgs int) int {
-	lsFrom := from.(*luaState)
-	if lsFrom.coChan == nil {
-		lsFrom.coChan = make(chan int)
+	if self.status == LUA_OK { /* may be starting a coroutine */
+		if self.stack.prev != nil { /* not in base level? */
+			return self.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if self.status != LUA_YIELD {
+		return self.resumeError("cannot resume dead coroutine", nArgs)
+	}
+
+	oldNny := self.nny
+	self.nny = 0 /* allow yields */
+	err := self.protect(func() { self.resume(nArgs) })
+	for err != nil { /* continue running after recoverable errors */
+		stack := self.findPCall()
+		if stack == nil { /* unrecoverable error */
+			self.status = err.Status /* mark thread as 'dead' */
+			self.errObj = err.Value
+			self.stack.check(1)
+			self.stack.push(err.Value)
+			break
+		}
+		e := err
+		err = self.protect(func() { self.recover(stack, e) })
+	}
+	self.nny = oldNny
+	return self.status
+}
+
+// lua-5.3.4/src/ldo.c#resume_error()
+func (self *luaState) resumeError(msg string, nArgs int) int {
+	self.Pop(nArgs)
+	self.stack.check(1)
+	self.stack.push(msg)
+	return LUA_ERRRUN
+}
+
+// lua-5.3.4/src/ldo.c#resume()
+func (self *luaState) resume(nArgs int) {
+	if self.status == LUA_OK { /* starting a coroutine? */
+		self.call(nArgs, LUA_MULTRET)
+		return
+	}
+
+	/* resuming from previous yield */
+	self.status = LUA_OK /* mark that it is running (again) */
+	stack := self.stack
+	if stack.k != nil { /* does it have a continuation function? */
+		args := stack.popN(nArgs)
+		stack.check(len(stack.saved) + nArgs)
+		stack.pushN(stack.saved, -1)
+		stack.pushN(args, nArgs)
+		stack.saved = nil
+		nArgs = stack.k(self, LUA_YIELD, stack.ctx) /* call continuation */
+	}
+	stack.saved = nil
+	self.postCall(nArgs) /* the function that yielded returns */
+	self.unroll()
+}
+
+// runs the frames left by a yield, down to the base of the coroutine
+// lua-5.3.4/src/ldo.c#unroll()
+func (self *luaState) unroll() {
+	for self.stack.prev != nil { /* something in the stack */
+		stack := self.stack
+		if stack.closure.proto == nil { /* Go function? */
+			self.finishGoCall(LUA_YIELD) /* complete its execution */
+		} else { /* Lua function */
+			if !stack.hookYield { /* finish interrupted instruction */
+				if stack.leq { /* "<=" using "<"? */
+					stack.leq = false
+					stack.push(!convertToBoolean(stack.pop())) /* negate result */
+				}
+				vm.Instruction(stack.closure.proto.Code[stack.pc-1]).Finish(self)
+			}
+			self.runLuaClosure()
+			self.postCall(stack.top - int(stack.closure.proto.MaxStackSize))
+		}
 	}
+}
+
+// calls the continuation of a Go function interrupted by a yield
+// lua-5.3.4/src/ldo.c#finishCcall()
+func (self *luaState) finishGoCall(status int) {
+	stack := self.stack
+	stack.pcall = nil /* PCallK is done */
+	n := stack.k(self, status, stack.ctx)
+	self.postCall(n)
+}
 
-	if self.coChan == nil {
-		// start coroutine
-		self.coChan = make(chan int)
-		self.coCaller = lsFrom
-		go func() {
-			self.coStatus = self.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
-		}()
-	} else {
-		// resume coroutine
-		if self.coStatus != LUA_YIELD { // todo
-			self.stack.push("cannot resume non-suspended coroutine")
-			return LUA_ERRRUN
+// runs f, stopping at a yield or at an error raised by the coroutine;
+// the message handler of the PCallK that will catch it is called here
+func (self *luaState) protect(f func()) (err *LuaError) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(coYield); ok {
+				return
+			}
+			err = self.newLuaError(r, false)
+			if stack := self.findPCall(); stack != nil {
+				h := stack.pcall.handler
+				if h != nil && err.Status == LUA_ERRRUN {
+					self.callMsgHandler(h, err)
+				}
+			}
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// lua-5.3.4/src/ldo.c#findpcall()
+func (self *luaState) findPCall() *luaStack {
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		if stack.pcall != nil {
+			return stack
 		}
-		self.coStatus = LUA_OK
-		self.coChan <- 1
 	}
+	return nil /* no pending pcall */
+}
 
-	<-lsFrom.coChan // wait coroutine to finish or yield
-	return self.coStatus
+// unwinds to the Go function that called PCallK and passes
+// the error to its continuation, then goes on running
+// lua-5.3.4/src/ldo.c#recover()
+func (self *luaState) recover(stack *luaStack, err *LuaError) {
+	for self.stack != stack {
+		self.popLuaStack()
+	}
+	self.SetTop(stack.pcall.oldTop)
+	stack.check(1)
+	stack.push(err.Value)
+	self.nny = 0 /* should be zero to be yieldable */
+	self.finishGoCall(err.Status)
+	self.unroll()
 }
 
 // [-?, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_yield
 func (self *luaState) Yield(nResults int) int {
-	if self.coCaller == nil { // todo
-		panic("attempt to yield from outside a coroutine")
+	return self.YieldK(nResults, 0, nil)
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yieldk
+// lua-5.3.4/src/ldo.c#lua_yieldk()
+func (self *luaState) YieldK(nResults int, ctx KContext, k KFunction) int {
+	if self.nny > 0 {
+		if self.isMainThread() {
+			panic("attempt to yield from outside a coroutine")
+		}
+		panic("attempt to yield across a C-call boundary")
 	}
-	self.coStatus = LUA_YIELD
-	self.coCaller.coChan <- 1
-	<-self.coChan
-	return self.GetTop()
+
+	stack := self.stack
+	self.status = LUA_YIELD
+	stack.k = k /* save continuation */
+	stack.ctx = ctx
+	/* leave only the yielded values to the resumer */
+	vals := stack.popN(nResults)
+	stack.saved = stack.popN(stack.top)
+	stack.pushN(vals, nResults)
+	panic(coYield{})
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
 func (self *luaState) IsYieldable() bool {
-	if self.isMainThread() {
-		return false
-	}
-	return self.coStatus != LUA_YIELD // todo
+	return self.nny == 0
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_status
 // lua-5.3.4/src/lapi.c#lua_status()
 func (self *luaState) Status() int {
-	return self.coStatus
+	return self.status
 }
 
-// debug
-func (self *luaState) GetStack() bool {
-	return self.stack.prev != nil
+// [-0, +?, –]
+// http://www.lua.org/manual/5.4/manual.html#lua_closethread
+// Resets a suspended or dead coroutine so that it can be collected.
+// Returns the status of a coroutine stopped by an error, leaving the
+// error object on the top of its stack, or LUA_OK.
+func (self *luaState) CloseThread(from LuaState) int {
+	status := self.status
+	if status == LUA_YIELD {
+		status = LUA_OK
+	}
+
+	/* drop all frames and values */
+	self.stack = nil
+	self.pushLuaStack(newLuaStack(LUA_MINSTACK, self))
+	self.status = LUA_OK
+	self.nny = 1
+	if status != LUA_OK {
+		self.stack.push(self.errObj)
+	}
+	self.errObj = nil
+	return status
 }
@@ -0,0 +1,215 @@
+package state
+
+import "strings"
+import "github.com/tdkr/go-luavm/src/compiler/lexer"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+func (self *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	uv, name, ok := self.auxUpvalue(funcIdx, n)
+	if ok {
+		self.stack.push(*uv.val)
+	}
+	return name, ok
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+func (self *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	uv, name, ok := self.auxUpvalue(funcIdx, n)
+	if ok {
+		*uv.val = self.stack.pop()
+	}
+	return name, ok
+}
+
+// lua-5.3.4/src/lapi.c#aux_upvalue()
+func (self *luaState) auxUpvalue(funcIdx, n int) (*upvalue, string, bool) {
+	c, ok := self.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) || c.upvals[n-1] == nil {
+		return nil, "", false
+	}
+	if c.proto == nil { /* Go closure */
+		return c.upvals[n-1], "", true
+	}
+	if names := c.proto.UpvalueNames; n <= len(names) {
+		return c.upvals[n-1], names[n-1], true
+	}
+	return c.upvals[n-1], "(*no name)", true
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
+// lua-5.3.4/src/lapi.c#lua_upvalueid()
+func (self *luaState) UpvalueID(funcIdx, n int) interface{} {
+	if uv, _, ok := self.auxUpvalue(funcIdx, n); ok {
+		return uv
+	}
+	return nil
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+// lua-5.3.4/src/lapi.c#lua_upvaluejoin()
+func (self *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	c1 := self.stack.get(funcIdx1).(*closure)
+	c2 := self.stack.get(funcIdx2).(*closure)
+	c1.upvals[n1-1] = c2.upvals[n2-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+// lua-5.3.4/src/ldebug.c#lua_getstack()
+func (self *luaState) GetStack(level int, ar *LuaDebug) bool {
+	if level < 0 {
+		return false /* invalid (negative) level */
+	}
+	stack := self.stack
+	for ; stack.isHook || level > 0 && stack.prev != nil; stack = stack.prev {
+		if !stack.isHook { /* hook frames are not levels */
+			level--
+		}
+	}
+	if level == 0 && stack.prev != nil { /* level found? */
+		ar.CallInfo = stack
+		return true
+	}
+	return false /* no such level */
+}
+
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+// lua-5.3.4/src/ldebug.c#lua_getinfo()
+func (self *luaState) GetInfo(what string, ar *LuaDebug) bool {
+	var stack *luaStack
+	var fn luaValue
+	if strings.HasPrefix(what, ">") {
+		fn = self.stack.pop() /* pop function */
+		what = what[1:]       /* skip the '>' */
+	} else {
+		stack = ar.CallInfo.(*luaStack)
+		fn = stack.closure
+	}
+	c, _ := fn.(*closure)
+	ok := auxGetInfo(what, ar, c, stack)
+	if strings.IndexByte(what, 'f') >= 0 {
+		self.stack.check(1)
+		self.stack.push(fn)
+	}
+	if strings.IndexByte(what, 'L') >= 0 {
+		self.collectValidLines(c)
+	}
+	return ok
+}
+
+// lua-5.3.4/src/ldebug.c#auxgetinfo()
+func auxGetInfo(what string, ar *LuaDebug, c *closure, stack *luaStack) bool {
+	ok := true
+	for _, option := range what {
+		switch option {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if stack != nil {
+				ar.CurrentLine = stack.currentLine()
+			}
+		case 'u':
+			ar.NUps = 0
+			if c != nil {
+				ar.NUps = len(c.upvals)
+			}
+			if c == nil || c.proto == nil {
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.IsVararg = c.proto.IsVararg == 1
+				ar.NParams = int(c.proto.NumParams)
+			}
+		case 't':
+			ar.IsTailCall = false /* tail calls keep their frames */
+		case 'n':
+			ar.Name, ar.NameWhat = "", ""
+			if stack != nil {
+				ar.Name, ar.NameWhat = getFuncName(stack)
+			}
+		case 'L', 'f': /* handled by GetInfo */
+		default:
+			ok = false /* invalid option */
+		}
+	}
+	return ok
+}
+
+// lua-5.3.4/src/ldebug.c#funcinfo()
+func funcInfo(ar *LuaDebug, c *closure) {
+	if c == nil || c.proto == nil {
+		ar.Source = "=[C]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "C"
+	} else {
+		p := c.proto
+		ar.Source = p.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(p.LineDefined)
+		ar.LastLineDefined = int(p.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = lexer.ChunkID(ar.Source)
+}
+
+// lua-5.3.4/src/ldebug.c#collectvalidlines()
+func (self *luaState) collectValidLines(c *closure) {
+	if c == nil || c.proto == nil {
+		self.stack.check(1)
+		self.stack.push(nil)
+		return
+	}
+	lineInfo := c.proto.LineInfo
+	self.CreateTable(0, len(lineInfo)) /* new table to store active lines */
+	t := self.stack.get(-1).(*luaTable)
+	for _, line := range lineInfo {
+		t.put(int64(line), true)
+	}
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+// lua-5.3.4/src/ldebug.c#lua_getlocal()
+func (self *luaState) GetLocal(ar *LuaDebug, n int) (string, bool) {
+	if ar == nil { /* information about non-active function? */
+		c, ok := self.stack.get(-1).(*closure)
+		if !ok || c.proto == nil { /* not a Lua function? */
+			return "", false
+		}
+		/* consider live variables at function start (parameters) */
+		name := getLocalName(c.proto, n, 0)
+		return name, name != ""
+	}
+
+	pos, name := ar.CallInfo.(*luaStack).findLocal(n)
+	if pos != nil {
+		self.stack.check(1)
+		self.stack.push(*pos)
+	}
+	return name, pos != nil
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+// lua-5.3.4/src/ldebug.c#lua_setlocal()
+func (self *luaState) SetLocal(ar *LuaDebug, n int) (string, bool) {
+	pos, name := ar.CallInfo.(*luaStack).findLocal(n)
+	if pos != nil {
+		*pos = self.stack.pop() /* pop value */
+	}
+	return name, pos != nil
+}
@@ -11,10 +11,18 @@ func (self *luaState) NewTable() {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_createtable
 func (self *luaState) CreateTable(nArr, nRec int) {
+	self.allocate(sizeTable + int64(nArr+nRec)*sizeTableEntry)
 	t := newLuaTable(nArr, nRec)
 	self.stack.push(t)
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+func (self *luaState) NewUserdata(data interface{}) {
+	self.allocate(sizeUserdata)
+	self.stack.push(newUserdata(data))
+}
+
 // [-1, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_gettable
 func (self *luaState) GetTable(idx int) LuaType {
@@ -72,6 +80,17 @@ func (self *luaState) GetMetatable(idx int) bool {
 	}
 }
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getuservalue
+func (self *luaState) GetUserValue(idx int) LuaType {
+	val := self.stack.get(idx)
+	if u, ok := val.(*userdata); ok {
+		self.stack.push(u.uservalue)
+		return typeOf(u.uservalue)
+	}
+	panic("userdata expected!")
+}
+
 // push(t[k])
 func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
 	if tbl, ok := t.(*luaTable); ok {
@@ -91,12 +110,13 @@ func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
 				self.stack.push(mf)
 				self.stack.push(t)
 				self.stack.push(k)
-				self.Call(2, 1)
+				self.callTM(2, 1)
 				v := self.stack.get(-1)
 				return typeOf(v)
 			}
 		}
 	}
 
-	panic("index error!")
+	self.valueTypeError(t, "index")
+	return LUA_TNIL
 }
@@ -0,0 +1,115 @@
+package state
+
+import . "github.com/tdkr/go-luavm/src/api"
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+// lua-5.3.4/src/ldebug.c#lua_sethook()
+func (self *luaState) SetHook(f LuaHook, mask, count int) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	if stack := self.stack; stack.closure != nil && stack.closure.proto != nil {
+		self.oldPC = stack.pc - 1
+	}
+	self.hook = f
+	self.baseHookCount = count
+	self.hookCount = count
+	self.hookMask = mask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+func (self *luaState) GetHook() LuaHook {
+	return self.hook
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+func (self *luaState) GetHookMask() int {
+	return self.hookMask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+func (self *luaState) GetHookCount() int {
+	return self.baseHookCount
+}
+
+// calls the hook for an event of the running function; the hook
+// gets a frame of its own, which GetStack and tracebacks skip
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (self *luaState) callHook(event, line int) {
+	if self.hook == nil || self.inHook {
+		return
+	}
+	ar := &LuaDebug{Event: event, CurrentLine: line, CallInfo: self.stack}
+	stack := newLuaStack(LUA_MINSTACK, self)
+	stack.closure = &closure{}
+	stack.isHook = true
+	self.pushLuaStack(stack)
+
+	self.inHook = true /* cannot call hooks inside a hook */
+	defer func() { self.inHook = false }()
+	if event == LUA_HOOKCALL || event == LUA_HOOKRET {
+		self.nny++ /* only line and count hooks can yield */
+		defer func() { self.nny-- }()
+	}
+	self.hook(self, ar)
+	self.popLuaStack()
+}
+
+// calls the line and count hooks before an instruction is executed
+// lua-5.3.4/src/lvm.c#traceexec()
+func (self *luaState) traceExec() {
+	stack := self.stack
+	mask := self.hookMask
+	self.hookCount--
+	countHook := self.hookCount == 0 && mask&LUA_MASKCOUNT != 0
+	if countHook {
+		self.hookCount = self.baseHookCount /* reset count */
+	} else if mask&LUA_MASKLINE == 0 {
+		return /* no line hook and count != 0; nothing to be done */
+	}
+	if stack.hookYield { /* called hook last time? */
+		stack.hookYield = false
+		return /* do not call hook again (coroutine yielded, so it did not move) */
+	}
+
+	pc := stack.pc - 1
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(coYield); ok { /* did hook yield? */
+				self.oldPC = pc
+				if countHook {
+					self.hookCount = 1 /* undo decrement to zero */
+				}
+				stack.pc--             /* run the instruction again on resume */
+				stack.hookYield = true /* mark that it yielded */
+			}
+			panic(r)
+		}
+	}()
+
+	if countHook {
+		self.callHook(LUA_HOOKCOUNT, -1) /* call count hook */
+	}
+	if mask&LUA_MASKLINE != 0 {
+		lineInfo := stack.closure.proto.LineInfo
+		newLine := lineAt(lineInfo, pc)
+		if pc == 0 || /* call linehook when enter a new function, */
+			pc <= self.oldPC || /* when jump back (loop), or when */
+			newLine != lineAt(lineInfo, self.oldPC) { /* enter a new line */
+			self.callHook(LUA_HOOKLINE, newLine) /* call line hook */
+		}
+	}
+	self.oldPC = pc
+}
+
+func lineAt(lineInfo []uint32, pc int) int {
+	if pc >= 0 && pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	}
+	return -1
+}
@@ -0,0 +1,77 @@
+package state
+
+import "context"
+
+// [-0, +0, –]
+// Attaches ctx to the state and all of its threads. Once ctx is done,
+// running Lua code raises an error that pcall can catch but not
+// recover from: every later attempt to run code raises it again.
+func (self *luaState) SetContext(ctx context.Context) {
+	g := self.g
+	g.ctx = ctx
+	g.done = nil
+	g.ctxErr = nil
+	g.ctxTick = 0 // poll it before the next instruction
+	if ctx != nil {
+		g.done = ctx.Done()
+	}
+	g.limited = g.done != nil || g.instLimit > 0
+}
+
+// [-0, +0, –]
+func (self *luaState) Context() context.Context {
+	if self.g.ctx == nil {
+		return context.Background()
+	}
+	return self.g.ctx
+}
+
+// [-0, +0, –]
+// Allows n more instructions to run, n <= 0 removes the limit.
+// The instruction counter is reset.
+func (self *luaState) SetInstructionLimit(n int64) {
+	g := self.g
+	if n < 0 {
+		n = 0
+	}
+	g.instLimit = n
+	g.instCount = 0
+	g.limited = g.done != nil || g.instLimit > 0
+}
+
+// [-0, +0, –]
+// Number of instructions run since the last SetInstructionLimit.
+func (self *luaState) InstructionCount() int64 {
+	return self.g.instCount
+}
+
+// [-0, +0, –]
+// Sets the memory ceiling of the state and all of its threads in bytes,
+// limit <= 0 removes it. Exceeding it raises a LUA_ERRMEM error.
+// Garbage is only found by measuring the live objects again, which is
+// not done more often than every quarter of the usage allocated, so a
+// script that keeps more than 4/5 of the limit alive may fail early.
+func (self *luaState) SetMemoryLimit(limit int64) {
+	if limit < 0 {
+		limit = 0
+	}
+	self.g.memLimit = limit
+	if limit > 0 {
+		self.remeasure()
+	}
+}
+
+// [-0, +0, –]
+// Measures the memory used by the objects reachable from the state.
+// The cost is proportional to the number of live objects.
+func (self *luaState) MemoryUsage() int64 {
+	self.remeasure()
+	return self.g.memUsed
+}
+
+// [-0, +0, m]
+// Raises a memory error unless n more bytes fit under the limit,
+// to be called before building large values in Go functions.
+func (self *luaState) CheckMemory(n int64) {
+	self.reserve(n)
+}
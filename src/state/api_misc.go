@@ -14,7 +14,7 @@ func (self *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		self.stack.push(int64(t.len()))
 	} else {
-		panic("length error!")
+		self.valueTypeError(val, "get length of")
 	}
 }
 
@@ -28,6 +28,7 @@ func (self *luaState) Concat(n int) {
 			if self.IsString(-1) && self.IsString(-2) {
 				s2 := self.ToString(-1)
 				s1 := self.ToString(-2)
+				self.allocate(sizeString + int64(len(s1)+len(s2)))
 				self.stack.pop()
 				self.stack.pop()
 				self.stack.push(s1 + s2)
@@ -41,7 +42,7 @@ func (self *luaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error!")
+			self.concatError(a, b)
 		}
 	}
 	// n == 1, do nothing
@@ -65,11 +66,24 @@ func (self *luaState) Next(idx int) bool {
 
 // [-1, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#lua_error
+// lua-5.4.6/src/lapi.c#lua_error()
 func (self *luaState) Error() int {
 	err := self.stack.pop()
+	if ie := self.g.interrupt(); ie != nil { /* script was stopped? */
+		panic(ie) /* no other error may replace it */
+	}
+	if err == nil {
+		panic(nilError{}) /* recover cannot tell panic(nil) from no panic */
+	}
+	if err == memErrMsg { /* error object is the message for memory errors? */
+		panic(&memoryError{}) /* raise a memory error */
+	}
 	panic(err)
 }
 
+// raised by Error for a nil error object
+type nilError struct{}
+
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_stringtonumber
 func (self *luaState) StringToNumber(s string) bool {
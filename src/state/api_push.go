@@ -1,6 +1,7 @@
 package state
 
 import "fmt"
+import "reflect"
 import . "github.com/tdkr/go-luavm/src/api"
 
 // [-0, +1, –]
@@ -30,6 +31,7 @@ func (self *luaState) PushNumber(n float64) {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushstring
 func (self *luaState) PushString(s string) {
+	self.allocate(sizeString + int64(len(s)))
 	self.stack.push(s)
 }
 
@@ -37,6 +39,7 @@ func (self *luaState) PushString(s string) {
 // http://www.lua.org/manual/5.3/manual.html#lua_pushfstring
 func (self *luaState) PushFString(fmtStr string, a ...interface{}) {
 	str := fmt.Sprintf(fmtStr, a...)
+	self.allocate(sizeString + int64(len(str)))
 	self.stack.push(str)
 }
 
@@ -57,6 +60,21 @@ func (self *luaState) PushGoClosure(f GoFunction, n int) {
 	self.stack.push(closure)
 }
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
+// p must be nil, a pointer, an unsafe.Pointer or a channel, so that
+// light userdata can be compared and used as table keys.
+func (self *luaState) PushLightUserdata(p interface{}) {
+	if p != nil {
+		switch reflect.TypeOf(p).Kind() {
+		case reflect.Ptr, reflect.UnsafePointer, reflect.Chan:
+		default:
+			panic(fmt.Sprintf("light userdata must be a pointer, got %T", p))
+		}
+	}
+	self.stack.push(lightUserdata{p})
+}
+
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushglobaltable
 func (self *luaState) PushGlobalTable() {
@@ -1,5 +1,6 @@
 package state
 
+import "math"
 import . "github.com/tdkr/go-luavm/src/api"
 
 // [-2, +0, e]
@@ -74,11 +75,36 @@ func (self *luaState) SetMetatable(idx int) {
 	}
 }
 
+// [-1, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setuservalue
+func (self *luaState) SetUserValue(idx int) {
+	val := self.stack.get(idx)
+	uv := self.stack.pop()
+	if u, ok := val.(*userdata); ok {
+		u.uservalue = uv
+		return
+	}
+	panic("userdata expected!")
+}
+
+// puts k, v into the table, accounting for the new entry
+func (self *luaState) putTable(t *luaTable, k, v luaValue) {
+	if k == nil {
+		self.runError("table index is nil")
+	} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+		self.runError("table index is NaN")
+	}
+	if v != nil && self.g.memLimit > 0 && t.get(k) == nil {
+		self.allocate(sizeTableEntry)
+	}
+	t.put(k, v)
+}
+
 // t[k]=v
 func (self *luaState) setTable(t, k, v luaValue, raw bool) {
 	if tbl, ok := t.(*luaTable); ok {
 		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.put(k, v)
+			self.putTable(tbl, k, v)
 			return
 		}
 	}
@@ -94,11 +120,11 @@ func (self *luaState) setTable(t, k, v luaValue, raw bool) {
 				self.stack.push(t)
 				self.stack.push(k)
 				self.stack.push(v)
-				self.Call(3, 0)
+				self.callTM(3, 0)
 				return
 			}
 		}
 	}
 
-	panic("index error!")
+	self.valueTypeError(t, "index")
 }
@@ -2,6 +2,8 @@ package state
 
 import "fmt"
 import "io/ioutil"
+import "os"
+import "strings"
 import . "github.com/tdkr/go-luavm/src/api"
 
 import "github.com/tdkr/go-luavm/src/stdlib"
@@ -9,15 +11,68 @@ import "github.com/tdkr/go-luavm/src/stdlib"
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_error
 func (self *luaState) Error2(fmt string, a ...interface{}) int {
-	self.PushFString(fmt, a...) // todo
+	self.Where(1)
+	self.PushFString(fmt, a...)
+	self.Concat(2)
 	return self.Error()
 }
 
+// [-0, +0, v]
+// Raises err as Error2 raises its message, prefixed with the position.
+// The LuaError returned by the protected calls keeps err as its Cause,
+// Lua code catching the error gets the message.
+func (self *luaState) ErrorE(err error) int {
+	self.Where(1)
+	msg := self.stack.pop().(string) + err.Error()
+	if ie := self.g.interrupt(); ie != nil { /* script was stopped? */
+		panic(ie)
+	}
+	panic(&goError{msg, err})
+}
+
+// raised by ErrorE
+type goError struct {
+	msg string
+	err error
+}
+
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_argerror
+// lua-5.3.4/src/lauxlib.c#luaL_argerror()
 func (self *luaState) ArgError(arg int, extraMsg string) int {
-	// bad argument #arg to 'funcname' (extramsg)
-	return self.Error2("bad argument #%d (%s)", arg, extraMsg) // todo
+	var ar LuaDebug
+	if !self.GetStack(0, &ar) { /* no stack frame? */
+		return self.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	self.GetInfo("n", &ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return self.Error2("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.Name == "" {
+		if name, ok := self.globalFuncName(&ar); ok {
+			ar.Name = name
+		} else {
+			ar.Name = "?"
+		}
+	}
+	return self.Error2("bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_where
+func (self *luaState) Where(lvl int) {
+	var ar LuaDebug
+	if self.GetStack(lvl, &ar) { /* check function at level */
+		self.GetInfo("Sl", &ar) /* get info about it */
+		if ar.CurrentLine > 0 { /* is there info? */
+			self.PushFString("%s:%d: ", ar.ShortSrc, ar.CurrentLine)
+			return
+		}
+	}
+	self.PushString("") /* else, no information available... */
 }
 
 // [-0, +0, v]
@@ -114,6 +169,50 @@ func (self *luaState) OptString(arg int, def string) string {
 	return self.CheckString(arg)
 }
 
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkoption
+// An empty def means the argument has no default.
+func (self *luaState) CheckOption(arg int, def string, lst []string) int {
+	name := def
+	if def == "" || !self.IsNoneOrNil(arg) {
+		name = self.CheckString(arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return self.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+func (self *luaState) TestUdata(arg int, tname string) interface{} {
+	if self.isUdata(arg, tname) {
+		return self.ToUserdata(arg)
+	}
+	return nil /* value is not a userdata with the given metatable */
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+func (self *luaState) CheckUdata(arg int, tname string) interface{} {
+	if !self.isUdata(arg, tname) {
+		self.typeError(arg, tname)
+	}
+	return self.ToUserdata(arg)
+}
+
+func (self *luaState) isUdata(arg int, tname string) bool {
+	if self.Type(arg) != LUA_TUSERDATA || !self.GetMetatable(arg) {
+		return false /* not a userdata or without metatable */
+	}
+	self.GetMetatable2(tname)   /* get correct metatable */
+	ok := self.RawEqual(-1, -2) /* the same? */
+	self.Pop(2)                 /* remove both metatables */
+	return ok
+}
+
 // [-0, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_dofile
 func (self *luaState) DoFile(filename string) bool {
@@ -128,6 +227,36 @@ func (self *luaState) DoString(str string) bool {
 		self.PCall(0, LUA_MULTRET, 0) != LUA_OK
 }
 
+// [-0, +?, –]
+// Like DoFile, but on failure nothing is left on the stack and the
+// error is returned as a *LuaError.
+func (self *luaState) DoFileE(filename string) error {
+	if status := self.LoadFile(filename); status != LUA_OK {
+		return self.loadError(status)
+	}
+	return self.PCallE(0, LUA_MULTRET)
+}
+
+// [-0, +?, –]
+// Like DoString, but on failure nothing is left on the stack and the
+// error is returned as a *LuaError.
+func (self *luaState) DoStringE(str string) error {
+	if status := self.LoadString(str); status != LUA_OK {
+		return self.loadError(status)
+	}
+	return self.PCallE(0, LUA_MULTRET)
+}
+
+// pops the message left by a failed load
+func (self *luaState) loadError(status int) error {
+	msg := self.ToString(-1)
+	return &LuaError{
+		Status:  status,
+		Value:   self.stack.pop(),
+		Message: msg,
+	}
+}
+
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfile
 func (self *luaState) LoadFile(filename string) int {
@@ -136,11 +265,20 @@ func (self *luaState) LoadFile(filename string) int {
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfilex
+// An empty filename loads from the standard input.
 func (self *luaState) LoadFileX(filename, mode string) int {
-	if data, err := ioutil.ReadFile(filename); err == nil {
-		return self.Load(data, "@" + filename, mode)
+	if filename == "" {
+		return self.LoadReader(os.Stdin, "=stdin", mode)
 	}
-	return LUA_ERRFILE
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		self.PushFString("cannot open %s: %s", filename, err)
+		return LUA_ERRFILE
+	}
+	return self.Load(data, "@"+filename, mode)
 }
 
 // [-0, +1, –]
@@ -254,24 +392,264 @@ func (self *luaState) CallMeta(obj int, event string) bool {
 	return true
 }
 
+// index of the free list in tables used by Ref
+const freelist = 0
+
+// [-1, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_ref
+// lua-5.3.4/src/lauxlib.c#luaL_ref()
+func (self *luaState) Ref(t int) int {
+	if self.IsNil(-1) {
+		self.Pop(1)       /* remove it from stack */
+		return LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = self.AbsIndex(t)
+	self.RawGetI(t, freelist)      /* get first free element */
+	ref := int(self.ToInteger(-1)) /* ref = t[freelist] */
+	self.Pop(1)                    /* remove it from stack */
+	if ref != 0 {                  /* any free element? */
+		self.RawGetI(t, int64(ref)) /* remove it from list */
+		self.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else {
+		ref = int(self.RawLen(t)) + 1 /* get a new reference */
+	}
+	self.RawSetI(t, int64(ref))
+	return ref
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_unref
+// lua-5.3.4/src/lauxlib.c#luaL_unref()
+func (self *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = self.AbsIndex(t)
+		self.RawGetI(t, freelist)
+		self.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		self.PushInteger(int64(ref))
+		self.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
+/* size of the first and second parts of the stack in tracebacks */
+const (
+	_LEVELS1 = 10
+	_LEVELS2 = 11
+)
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+// lua-5.3.4/src/lauxlib.c#luaL_traceback()
+// Unlike luaL_traceback, the traceback is returned rather than pushed.
+func (self *luaState) Traceback(L1 LuaState, msg string, level int) string {
+	var ar LuaDebug
+	last := lastLevel(L1)
+	n1 := -1
+	if last-level > _LEVELS1+_LEVELS2 {
+		n1 = _LEVELS1
+	}
+	var buf strings.Builder
+	if msg != "" {
+		buf.WriteString(msg)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("stack traceback:")
+	for L1.GetStack(level, &ar) {
+		level++
+		if n1 == 0 { /* too many levels? */
+			buf.WriteString("\n\t...")  /* add a '...' */
+			level = last - _LEVELS2 + 1 /* and skip to last ones */
+		} else {
+			L1.GetInfo("Slnt", &ar)
+			fmt.Fprintf(&buf, "\n\t%s:", ar.ShortSrc)
+			if ar.CurrentLine > 0 {
+				fmt.Fprintf(&buf, "%d:", ar.CurrentLine)
+			}
+			buf.WriteString(" in ")
+			buf.WriteString(self.funcName(&ar))
+			if ar.IsTailCall {
+				buf.WriteString("\n\t(...tail calls...)")
+			}
+		}
+		n1--
+	}
+	return buf.String()
+}
+
+// lua-5.3.4/src/lauxlib.c#lastlevel()
+func lastLevel(ls LuaState) int {
+	var ar LuaDebug
+	li, le := 1, 1
+	/* find an upper bound */
+	for ls.GetStack(le, &ar) {
+		li = le
+		le *= 2
+	}
+	/* do a binary search */
+	for li < le {
+		m := (li + le) / 2
+		if ls.GetStack(m, &ar) {
+			li = m + 1
+		} else {
+			le = m
+		}
+	}
+	return le - 1
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func (self *luaState) funcName(ar *LuaDebug) string {
+	if name, ok := self.globalFuncName(ar); ok { /* try first a global name */
+		return fmt.Sprintf("function '%s'", name)
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		return fmt.Sprintf("%s '%s'", ar.NameWhat, ar.Name) /* use it */
+	} else if ar.What == "main" {
+		return "main chunk"
+	} else if ar.What != "C" { /* for Lua functions, use <file:line> */
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	} else { /* nothing left... */
+		return "?"
+	}
+}
+
+// searches package.loaded for the function of ar
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname()
+func (self *luaState) globalFuncName(ar *LuaDebug) (string, bool) {
+	top := self.GetTop()
+	self.stack.check(4)
+	self.GetInfo("f", ar) /* push function */
+	self.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	defer self.SetTop(top) /* remove pushed values */
+	if self.findField(top+1, 2) {
+		return strings.TrimPrefix(self.ToString(-1), "_G."), true
+	}
+	return "", false
+}
+
+// lua-5.3.4/src/lauxlib.c#findfield()
+func (self *luaState) findField(objIdx, level int) bool {
+	if level == 0 || !self.IsTable(-1) {
+		return false /* not found */
+	}
+	self.stack.check(3)
+	self.PushNil()      /* start 'next' loop */
+	for self.Next(-2) { /* for each pair in table */
+		if self.Type(-2) == LUA_TSTRING { /* ignore non-string keys */
+			if self.RawEqual(objIdx, -1) { /* found object? */
+				self.Pop(1) /* remove value (but keep name) */
+				return true
+			} else if self.findField(objIdx, level-1) { /* try recursively */
+				self.Remove(-2) /* remove table (but keep name) */
+				self.PushString(".")
+				self.Insert(-2) /* place '.' between the two names */
+				self.Concat(3)
+				return true
+			}
+		}
+		self.Pop(1) /* remove value */
+	}
+	return false /* not found */
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newmetatable
+func (self *luaState) NewMetatable(tname string) bool {
+	if self.GetMetatable2(tname) != LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	self.Pop(1)
+	self.CreateTable(0, 2) /* create metatable */
+	self.PushString(tname)
+	self.SetField(-2, "__name") /* metatable.__name = tname */
+	self.PushValue(-1)
+	self.SetField(LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
+func (self *luaState) GetMetatable2(tname string) LuaType {
+	return self.GetField(LUA_REGISTRYINDEX, tname)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setmetatable
+func (self *luaState) SetMetatable2(tname string) {
+	self.GetMetatable2(tname)
+	self.SetMetatable(-2)
+}
+
 // [-0, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_openlibs
 func (self *luaState) OpenLibs() {
-	libs := map[string]GoFunction{
-		"_G":        stdlib.OpenBaseLib,
-		"math":      stdlib.OpenMathLib,
-		"table":     stdlib.OpenTableLib,
-		"string":    stdlib.OpenStringLib,
-		"utf8":      stdlib.OpenUTF8Lib,
-		"os":        stdlib.OpenOSLib,
-		"package":   stdlib.OpenPackageLib,
-		"coroutine": stdlib.OpenCoroutineLib,
-	}
-
-	for name, fun := range libs {
+	for name, fun := range stdlibs {
+		self.RequireF(name, fun, true)
+		self.Pop(1)
+	}
+}
+
+var stdlibs = map[string]GoFunction{
+	"_G":        stdlib.OpenBaseLib,
+	"math":      stdlib.OpenMathLib,
+	"table":     stdlib.OpenTableLib,
+	"string":    stdlib.OpenStringLib,
+	"utf8":      stdlib.OpenUTF8Lib,
+	"os":        stdlib.OpenOSLib,
+	"package":   stdlib.OpenPackageLib,
+	"coroutine": stdlib.OpenCoroutineLib,
+	"debug":     stdlib.OpenDebugLib,
+	"io":        stdlib.OpenIOLib,
+}
+
+// [-0, +0, e]
+// Opens the libraries listed by sb and removes what it hides,
+// the load mode of the state is restricted to sb.LoadMode.
+func (self *luaState) OpenSandbox(sb *Sandbox) {
+	if sb.LoadMode != "" {
+		self.g.loadMode = sb.LoadMode
+	}
+	for _, name := range sb.Libs {
+		fun, found := stdlibs[name]
+		if !found {
+			self.Error2("unknown library '%s'", name)
+		}
 		self.RequireF(name, fun, true)
 		self.Pop(1)
 	}
+
+	if !sb.FileAccess {
+		self.hideGlobals("dofile", "loadfile", "package.searchpath")
+		top := self.GetTop()
+		if self.GetField(LUA_REGISTRYINDEX, "_LOADED") == LUA_TTABLE &&
+			self.GetField(-1, "package") == LUA_TTABLE &&
+			self.GetField(-1, "searchers") == LUA_TTABLE {
+			/* keep the preload searcher only */
+			for i := int64(self.RawLen(-1)); i > 1; i-- {
+				self.PushNil()
+				self.RawSetI(-2, i)
+			}
+		}
+		self.SetTop(top)
+	}
+	if sb.HideRawAccess {
+		self.hideGlobals("getmetatable", "rawget", "rawset")
+	}
+	self.hideGlobals(sb.Hide...)
+}
+
+// removes globals ("print") and library functions ("os.exit")
+func (self *luaState) hideGlobals(names ...string) {
+	for _, name := range names {
+		if i := strings.IndexByte(name, '.'); i < 0 {
+			self.PushNil()
+			self.SetGlobal(name)
+		} else if self.GetGlobal(name[:i]) == LUA_TTABLE {
+			self.PushNil()
+			self.SetField(-2, name[i+1:])
+			self.Pop(1)
+		} else {
+			self.Pop(1)
+		}
+	}
 }
 
 // [-0, +1, e]
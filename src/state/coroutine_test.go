@@ -0,0 +1,140 @@
+package state
+
+import "strings"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// runs chunk and returns its results converted to strings
+func run(t *testing.T, chunk string) string {
+	ls := New()
+	ls.OpenLibs()
+	top := ls.GetTop()
+	if err := ls.DoStringE(chunk); err != nil {
+		t.Fatalf("%s\n%s", err, chunk)
+	}
+	var results []string
+	for i, n := top+1, ls.GetTop(); i <= n; i++ {
+		results = append(results, ls.ToString2(i))
+		ls.Pop(1)
+	}
+	return strings.Join(results, " ")
+}
+
+/* an object whose metamethods all yield before answering */
+const yielding = `
+local Y = coroutine.yield
+local mt = {}
+mt.__index = function(t, k) Y("index") return k .. "!" end
+mt.__newindex = function(t, k, v) Y("newindex") rawset(t, k, v * 2) end
+mt.__add = function(a, b) Y("add") return 10 end
+mt.__unm = function(a) Y("unm") return 11 end
+mt.__concat = function(a, b) Y("concat") return "cc" end
+mt.__len = function(a) Y("len") return 12 end
+mt.__eq = function(a, b) Y("eq") return true end
+mt.__lt = function(a, b) Y("lt") return true end
+mt.__le = function(a, b) Y("le") return false end
+mt.__call = function(self, x) Y("call") return x + 1 end
+mt.__tostring = function(a) Y("tostring") return "obj" end
+local function new() return setmetatable({}, mt) end
+
+-- resumes f until it ends, returns the yielded values and its results
+local function drive(f)
+  local co, out = coroutine.create(f), {}
+  while true do
+    local r = table.pack(coroutine.resume(co))
+    assert(r[1], r[2])
+    if coroutine.status(co) == "dead" then
+      for i = 2, r.n do out[#out + 1] = tostring(r[i]) end
+      return table.concat(out, " ")
+    end
+    out[#out + 1] = r[2]
+  end
+end
+`
+
+func TestYieldInMetamethods(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"return new().x", "index x!"},
+		{"local o = new() o.x = 2 return rawget(o, 'x')", "newindex 4"},
+		{"return new() + 1, 1 + new()", "add add 10 10"},
+		{"return -new()", "unm 11"},
+		{"return 'a' .. new() .. 'b'", "concat acc"},
+		{"return #new()", "len 12"},
+		{"return new() == new(), new() ~= new()", "eq eq true false"},
+		{"return new() < new(), new() <= new(), new() > new()", "lt le lt true false true"},
+		{"if new() < new() then return 'then' end", "lt then"},
+		{"return new()(41)", "call 42"},
+		{"return tostring(new())", "tostring obj"},
+		{"for k in function() Y('iter') end do end return 'done'", "iter done"},
+		{"local o = new() for i = 1, 2 do o.n = i end return rawget(o, 'n')", "newindex 2"},
+		{"return select(2, pcall(function() return new().k end))", "index k!"},
+		{"return select(2, pcall(function() local a = new() + new() error(a) end))", "add 10"},
+	}
+	for _, test := range tests {
+		chunk := yielding + "return drive(function() " + test.body + " end)"
+		if got := run(t, chunk); got != test.want {
+			t.Errorf("%s\ngot  %s\nwant %s", test.body, got, test.want)
+		}
+	}
+}
+
+func TestYieldAcrossGoFunction(t *testing.T) {
+	/* table.sort cannot be continued, so yielding from its comparator still fails */
+	got := run(t, yielding+`
+		local co = coroutine.create(function()
+			table.sort({3, 2, 1}, function(a, b) coroutine.yield() return a < b end)
+		end)
+		return coroutine.resume(co)`)
+	if got != "false attempt to yield across a C-call boundary" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestYieldInGoFunction(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.Register("twice", func(ls LuaState) int {
+		ls.PushValue(1) /* the yielded values leave the stack */
+		return ls.YieldK(1, 0, func(ls LuaState, status int, ctx KContext) int {
+			ls.PushInteger(ls.ToInteger(1) * 2)
+			return 1
+		})
+	})
+	err := ls.DoStringE(`
+		local co = coroutine.wrap(function(x) return twice(x) + 1 end)
+		assert(co(20) == 20)
+		result = co()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls.GetGlobal("result")
+	if n := ls.ToInteger(-1); n != 41 {
+		t.Errorf("got %d, want 41", n)
+	}
+}
+
+func TestYieldAfterErrorInNonYieldableCall(t *testing.T) {
+	/* the error unwinds a call that cannot yield, the coroutine still can */
+	got := run(t, `
+		local co = coroutine.create(function()
+			local ok, msg = pcall(function()
+				coroutine.yield(1)
+				table.sort({3, 2, 1}, function() error("cmp", 0) end)
+			end)
+			coroutine.yield(msg)
+			local ok2 = pcall(table.sort, {3, 2, 1}, function() error("cmp") end)
+			coroutine.yield(ok2)
+			return "end"
+		end)
+		local _, a = coroutine.resume(co)
+		local _, b = coroutine.resume(co)
+		local _, c = coroutine.resume(co)
+		local ok, d = coroutine.resume(co)
+		return a, b, c, ok, d`)
+	if got != "1 cmp false true end" {
+		t.Errorf("got %s", got)
+	}
+}
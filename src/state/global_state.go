@@ -0,0 +1,67 @@
+package state
+
+import "context"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// how many instructions run between two polls of the context
+const ctxCheckInterval = 1024
+
+// state shared by a main thread and all of its coroutines
+type globalState struct {
+	ctx       context.Context
+	done      <-chan struct{}
+	ctxErr    error // error of ctx once it is done
+	instLimit int64 // 0 means no limit
+	instCount int64
+	ctxTick   int    // instructions left until the context is polled
+	limited   bool   // ctx or instLimit is set
+	memLimit  int64  // 0 means no limit
+	memUsed   int64  // measured bytes plus everything allocated since
+	memBase   int64  // bytes found by the last measure
+	loadMode  string // chunks Load may accept, "" means "bt"
+}
+
+// raised when the running script has to be stopped,
+// Cause is ErrInstructionLimit or the error of the context
+type interruptError struct {
+	cause error
+}
+
+func (self *interruptError) Error() string {
+	return self.cause.Error()
+}
+
+// the error that stopped the script, nil if it may go on
+func (g *globalState) interrupt() *interruptError {
+	if g.instLimit > 0 && g.instCount > g.instLimit {
+		return &interruptError{ErrInstructionLimit}
+	}
+	if g.ctxErr != nil {
+		return &interruptError{g.ctxErr}
+	}
+	return nil
+}
+
+// called before every instruction
+func (self *luaState) step() {
+	g := self.g
+	g.instCount++
+	if !g.limited {
+		return
+	}
+	if err := g.interrupt(); err != nil { /* stopped for good? */
+		panic(err)
+	}
+	if g.done != nil {
+		if g.ctxTick--; g.ctxTick > 0 {
+			return
+		}
+		g.ctxTick = ctxCheckInterval
+		select {
+		case <-g.done:
+			g.ctxErr = g.ctx.Err()
+			panic(&interruptError{g.ctxErr})
+		default:
+		}
+	}
+}
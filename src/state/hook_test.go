@@ -0,0 +1,136 @@
+package state
+
+import "fmt"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+
+func TestCountHookYield(t *testing.T) {
+	/* preemptive scheduling: two busy loops take turns */
+	ls := New()
+	ls.OpenLibs()
+	ls.Register("preempt", func(ls LuaState) int {
+		ls.ToThread(1).SetHook(func(ls LuaState, ar *LuaDebug) {
+			ls.Yield(0)
+		}, LUA_MASKCOUNT, 100)
+		return 0
+	})
+	err := ls.DoStringE(`
+		local log = {}
+		local function worker(name)
+			local co = coroutine.create(function()
+				local n = 0
+				for i = 1, 1000 do n = n + i end
+				log[#log + 1] = name
+				return n
+			end)
+			preempt(co)
+			return co
+		end
+		local a, b = worker("a"), worker("b")
+		local switches = 0
+		while coroutine.status(a) ~= "dead" or coroutine.status(b) ~= "dead" do
+			for _, co in ipairs({a, b}) do
+				if coroutine.status(co) ~= "dead" then
+					local ok, n = coroutine.resume(co)
+					assert(ok, n)
+					if coroutine.status(co) == "dead" then assert(n == 500500) end
+					switches = switches + 1
+				end
+			end
+		end
+		assert(switches > 20, switches)
+		assert(#log == 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLineHookYield(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	var lines []int
+	ls.Register("trace", func(ls LuaState) int {
+		ls.ToThread(1).SetHook(func(ls LuaState, ar *LuaDebug) {
+			lines = append(lines, ar.CurrentLine)
+			ls.Yield(0)
+		}, LUA_MASKLINE, 0)
+		return 0
+	})
+	err := ls.DoStringE(`
+		local co = coroutine.create(function()
+			local a = 1; local b = 2; local c = a + b
+			local d = c * 2 + a * b - c
+			return d
+		end)
+		trace(co)
+		local n = 0
+		while true do
+			local ok, r = coroutine.resume(co)
+			assert(ok, r)
+			n = n + 1
+			if coroutine.status(co) == "dead" then
+				assert(r == 5, r)
+				break
+			end
+		end
+		assert(n == 4, n)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* every line is reported once, even though the hook yields */
+	if got := fmt.Sprint(lines); got != "[3 4 5]" {
+		t.Errorf("got lines %s, want [3 4 5]", got)
+	}
+}
+
+func TestHookCannotYieldFromCall(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.Register("callhook", func(ls LuaState) int {
+		ls.ToThread(1).SetHook(func(ls LuaState, ar *LuaDebug) {
+			ls.Yield(0)
+		}, LUA_MASKCALL, 0)
+		return 0
+	})
+	err := ls.DoStringE(`
+		local co = coroutine.create(function() return (function() return 1 end)() end)
+		callhook(co)
+		local ok, msg = coroutine.resume(co)
+		assert(not ok)
+		assert(msg:find("attempt to yield"), msg)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookErrorKeepsYieldable(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.Register("failhook", func(ls LuaState) int {
+		ls.ToThread(1).SetHook(func(ls LuaState, ar *LuaDebug) {
+			if ls.GetInfo("n", ar); ar.Name == "boom" {
+				ls.PushString("hook failed")
+				ls.Error()
+			}
+		}, LUA_MASKCALL, 0)
+		return 0
+	})
+	err := ls.DoStringE(`
+		local function boom() end
+		local co = coroutine.create(function()
+			local ok, msg = pcall(function()
+				coroutine.yield("in pcall")
+				boom()
+			end)
+			coroutine.yield(msg)
+			return "end"
+		end)
+		failhook(co)
+		for _, want in ipairs({"in pcall", "hook failed", "end"}) do
+			local ok, msg = coroutine.resume(co)
+			assert(ok and msg == want, msg)
+		end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
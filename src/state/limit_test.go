@@ -0,0 +1,109 @@
+package state
+
+import "context"
+import "errors"
+import "testing"
+import "time"
+import . "github.com/tdkr/go-luavm/src/api"
+
+/* scripts that try to keep running after catching the interrupt */
+var stubborn = []string{
+	`while true do end`,
+	`while true do pcall(function() while true do end end) end`,
+	`while true do xpcall(function() while true do end end, function(m) return m end) end`,
+	`while true do
+		local co = coroutine.create(function() while true do end end)
+		coroutine.resume(co)
+	end`,
+	`while true do
+		pcall(coroutine.wrap(function() while true do end end))
+	end`,
+	`local t = setmetatable({}, {__index = function() while true do end end})
+	while true do pcall(function() return t.x end) end`,
+}
+
+func TestContextDeadline(t *testing.T) {
+	for _, chunk := range stubborn {
+		ls := New()
+		ls.OpenLibs()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		ls.SetContext(ctx)
+
+		start := time.Now()
+		err := ls.DoStringE(chunk)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s\ngot %v, want the context error", chunk, err)
+		} else if err.(*LuaError).Status != LUA_ERRRUN {
+			t.Errorf("%s\ngot status %d", chunk, err.(*LuaError).Status)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s\nstopped after %v", chunk, d)
+		}
+
+		/* the error sticks until the context is replaced */
+		if err := ls.DoStringE(`x = 1`); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s\nrunning again: got %v", chunk, err)
+		}
+		ls.SetContext(nil)
+		if err := ls.DoStringE(`x = 1`); err != nil {
+			t.Errorf("%s\nafter SetContext(nil): %v", chunk, err)
+		}
+	}
+}
+
+func TestContextCanceled(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ctx, cancel := context.WithCancel(context.Background())
+	ls.SetContext(ctx)
+	ls.Register("cancel", func(ls LuaState) int {
+		cancel()
+		return 0
+	})
+	err := ls.DoStringE(`cancel() while true do end`)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v", err)
+	}
+	if ls.Context() != ctx {
+		t.Error("Context() does not return the attached context")
+	}
+}
+
+func TestInstructionLimit(t *testing.T) {
+	for _, chunk := range stubborn {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetInstructionLimit(10000)
+
+		err := ls.DoStringE(chunk)
+		if !errors.Is(err, ErrInstructionLimit) {
+			t.Errorf("%s\ngot %v, want ErrInstructionLimit", chunk, err)
+		}
+		if n := ls.InstructionCount(); n < 10000 || n > 10100 {
+			t.Errorf("%s\nstopped after %d instructions", chunk, n)
+		}
+
+		ls.SetInstructionLimit(0)
+		if err := ls.DoStringE(`for i = 1, 100000 do end`); err != nil {
+			t.Errorf("%s\nafter removing the limit: %v", chunk, err)
+		}
+	}
+}
+
+func TestInstructionLimitReset(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetInstructionLimit(1000)
+	if err := ls.DoStringE(`for i = 1, 100 do end`); err != nil {
+		t.Fatal(err)
+	}
+	used := ls.InstructionCount()
+	if used == 0 || used >= 1000 {
+		t.Fatalf("counted %d instructions", used)
+	}
+	ls.SetInstructionLimit(1000)
+	if ls.InstructionCount() != 0 {
+		t.Error("SetInstructionLimit does not reset the counter")
+	}
+}
@@ -0,0 +1,142 @@
+package state
+
+import "bytes"
+import "errors"
+import "io"
+import "strings"
+import "testing"
+import "testing/iotest"
+import . "github.com/tdkr/go-luavm/src/api"
+
+// returns data, then fails with err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (self *failingReader) Read(p []byte) (int, error) {
+	if len(self.data) == 0 {
+		return 0, self.err
+	}
+	n := copy(p, self.data)
+	self.data = self.data[n:]
+	return n, nil
+}
+
+/* a chunk longer than what the lexer reads at once */
+var longChunk = `
+local s = [[` + strings.Repeat("long string ", 2000) + `]]
+--[[` + strings.Repeat("long comment ", 2000) + `]]
+local function f(a, b) return a .. b end
+return #s, f("x", 'y'), 0x10, 7 // 2`
+
+func TestLoadReaderText(t *testing.T) {
+	readers := map[string]func(string) io.Reader{
+		"plain":    func(s string) io.Reader { return strings.NewReader(s) },
+		"one byte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"data err": func(s string) io.Reader { return iotest.DataErrReader(strings.NewReader(s)) },
+	}
+	for name, reader := range readers {
+		ls := New()
+		if status := ls.LoadReader(reader(longChunk), "=t", "bt"); status != LUA_OK {
+			t.Errorf("%s: status %d: %s", name, status, ls.ToString(-1))
+			continue
+		}
+		ls.Call(0, LUA_MULTRET)
+		if got := run2(ls); got != "24000 xy 16 3" {
+			t.Errorf("%s: got %s", name, got)
+		}
+
+		status := ls.LoadReader(reader("x = 1\nlocal = 2"), "=t", "bt")
+		if msg := ls.ToString(-1); status != LUA_ERRSYNTAX || msg != "t:2: <name> expected near '='" {
+			t.Errorf("%s: got %d: %s", name, status, msg)
+		}
+	}
+}
+
+func TestLoadReaderBinary(t *testing.T) {
+	ls := New()
+	if ls.LoadString(longChunk) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	dump := ls.Dump(false)
+	ls.SetTop(0)
+
+	if status := ls.LoadReader(iotest.OneByteReader(bytes.NewReader(dump)), "=t", "b"); status != LUA_OK {
+		t.Fatalf("status %d: %s", status, ls.ToString(-1))
+	}
+	ls.Call(0, LUA_MULTRET)
+	if got := run2(ls); got != "24000 xy 16 3" {
+		t.Errorf("got %s", got)
+	}
+
+	status := ls.LoadReader(bytes.NewReader(dump[:len(dump)/2]), "=t", "b")
+	if msg := ls.ToString(-1); status != LUA_ERRSYNTAX || !strings.HasPrefix(msg, "t: ") {
+		t.Errorf("truncated: got %d: %s", status, msg)
+	}
+}
+
+func TestLoadReaderMode(t *testing.T) {
+	ls := New()
+	if ls.LoadString("return 1") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	dump := ls.Dump(true)
+	tests := []struct {
+		chunk []byte
+		mode  string
+		want  string
+	}{
+		{[]byte("return 1"), "b", "attempt to load a text chunk (mode is 'b')"},
+		{dump, "t", "attempt to load a binary chunk (mode is 't')"},
+	}
+	for _, test := range tests {
+		status := ls.LoadReader(bytes.NewReader(test.chunk), "=t", test.mode)
+		if msg := ls.ToString(-1); status != LUA_ERRSYNTAX || msg != test.want {
+			t.Errorf("mode %s: got %d: %s", test.mode, status, msg)
+		}
+	}
+}
+
+func TestLoadReaderError(t *testing.T) {
+	ls := New()
+	if ls.LoadString(longChunk) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	dump := ls.Dump(false)
+	boom := errors.New("boom")
+	for name, data := range map[string][]byte{
+		"text":   []byte(longChunk[:len(longChunk)/2]),
+		"binary": dump[:len(dump)/2],
+	} {
+		status := ls.LoadReader(&failingReader{data, boom}, "=t", "bt")
+		if msg := ls.ToString(-1); status != LUA_ERRFILE || msg != "boom" {
+			t.Errorf("%s: got %d: %s", name, status, msg)
+		}
+	}
+}
+
+func TestLoadFunctionReader(t *testing.T) {
+	got := run(t, `
+		local pieces = {"return ", "'a", "b' .. ", "[[c", "]]", nil}
+		local i = 0
+		local f = assert(load(function() i = i + 1 return pieces[i] end))
+		local ok, msg = load(function() error("in reader") end)
+		local ok2, msg2 = load(function() return {} end)
+		return f(), ok, msg, ok2, msg2`)
+	want := "abc nil [string \"...\"]:5: in reader nil reader function must return a string"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+// pops the values on the stack and returns them converted to strings
+func run2(ls LuaState) string {
+	var results []string
+	for i, n := 1, ls.GetTop(); i <= n; i++ {
+		results = append(results, ls.ToString2(i))
+		ls.Pop(1)
+	}
+	ls.SetTop(0)
+	return strings.Join(results, " ")
+}
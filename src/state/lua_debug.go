@@ -0,0 +1,382 @@
+package state
+
+import "fmt"
+import "github.com/tdkr/go-luavm/src/binchunk"
+import "github.com/tdkr/go-luavm/src/compiler/lexer"
+import "github.com/tdkr/go-luavm/src/vm"
+
+// current line of a Lua function, or -1 if there is no line information
+func (self *luaStack) currentLine() int {
+	if self.closure == nil || self.closure.proto == nil {
+		return -1
+	}
+	lineInfo := self.closure.proto.LineInfo
+	if pc := self.pc - 1; pc >= 0 && pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	}
+	return -1
+}
+
+// chunk:line of the innermost running Lua function
+func (self *luaState) position() string {
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		if stack.closure != nil && stack.closure.proto != nil {
+			src := lexer.ChunkID(stack.closure.proto.Source)
+			if line := stack.currentLine(); line > 0 {
+				return fmt.Sprintf("%s:%d", src, line)
+			}
+			return src
+		}
+	}
+	return ""
+}
+
+// the traceback of a *LuaError, taken where the error was raised;
+// memory is not limited meanwhile, the error may be that it ran out
+func (self *luaState) traceback() string {
+	limit := self.g.memLimit
+	self.g.memLimit = 0
+	defer func() { self.g.memLimit = limit }()
+	return self.Traceback(self, "", 0)
+}
+
+// name of the n-th local variable active at pc, or ""
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func getLocalName(proto *binchunk.Prototype, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
+}
+
+// slot of the n-th local of a frame and its name, or nil
+// lua-5.3.4/src/ldebug.c#findlocal()
+func (self *luaStack) findLocal(n int) (*luaValue, string) {
+	name := ""
+	if proto := self.closure.proto; proto != nil {
+		if n < 0 { /* access to vararg values? */
+			if -n <= len(self.varargs) {
+				return &self.varargs[-n-1], "(*vararg)"
+			}
+			return nil, "" /* no such vararg */
+		}
+		name = getLocalName(proto, n, self.pc-1)
+	}
+	if name == "" { /* no 'standard' name? */
+		if n > 0 && n <= self.top { /* is 'n' inside 'ci' stack? */
+			name = "(*temporary)" /* generic name for any valid slot */
+		} else {
+			return nil, "" /* no name */
+		}
+	}
+	return &self.slots[n-1], name
+}
+
+// name of the function running in a frame, found from the calling
+// instruction, and what kind of name it is ("global", "method"...)
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func getFuncName(stack *luaStack) (name, nameWhat string) {
+	caller := stack.prev
+	if caller != nil && caller.isHook {
+		return "?", "hook"
+	}
+	if caller == nil || caller.closure == nil || caller.closure.proto == nil {
+		return "", "" /* calling function is not a Lua function */
+	}
+	return funcNameFromCode(caller)
+}
+
+/* metamethods of OP_ADD..OP_SHR */
+var arithEvents = []string{"__add", "__sub", "__mul", "__mod", "__pow",
+	"__div", "__idiv", "__band", "__bor", "__bxor", "__shl", "__shr"}
+
+// lua-5.3.4/src/ldebug.c#funcnamefromcode()
+func funcNameFromCode(stack *luaStack) (string, string) {
+	proto := stack.closure.proto
+	pc := stack.pc - 1 /* calling instruction */
+	i := vm.Instruction(proto.Code[pc])
+	event := ""
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(proto, pc, a) /* get function name */
+	case vm.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* other instructions can do calls through metamethods */
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		event = "__index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		event = "__newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		event = arithEvents[op-vm.OP_ADD]
+	case vm.OP_UNM:
+		event = "__unm"
+	case vm.OP_BNOT:
+		event = "__bnot"
+	case vm.OP_LEN:
+		event = "__len"
+	case vm.OP_CONCAT:
+		event = "__concat"
+	case vm.OP_EQ:
+		event = "__eq"
+	case vm.OP_LT:
+		event = "__lt"
+	case vm.OP_LE:
+		event = "__le"
+	default:
+		return "", ""
+	}
+	return event, "metamethod"
+}
+
+// finds a name for the value of register reg at lastpc
+// lua-5.3.4/src/ldebug.c#getobjname()
+func getObjName(proto *binchunk.Prototype, lastpc, reg int) (name, kind string) {
+	if name = getLocalName(proto, reg+1, lastpc); name != "" { /* is a local? */
+		return name, "local"
+	}
+
+	/* else try symbolic execution */
+	pc := findSetReg(proto, lastpc, reg)
+	if pc == -1 { /* could not find instruction */
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC() /* move from 'b' to 'a' */
+		if b < a {
+			return getObjName(proto, pc, b) /* get name for 'b' */
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC() /* table and key index */
+		var vn string      /* name of indexed variable */
+		if op == vm.OP_GETTABLE {
+			vn = getLocalName(proto, t+1, pc)
+		} else {
+			vn = upvalName(proto, t)
+		}
+		if vn == "_ENV" {
+			return kName(proto, pc, k), "global"
+		}
+		return kName(proto, pc, k), "field"
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return upvalName(proto, b), "upvalue"
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, b := i.ABx()
+		if op == vm.OP_LOADKX {
+			b = vm.Instruction(proto.Code[pc+1]).Ax()
+		}
+		if s, ok := proto.Constants[b].(string); ok {
+			return s, "constant"
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC() /* key index */
+		return kName(proto, pc, k), "method"
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+// lua-5.3.4/src/ldebug.c#kname()
+func kName(proto *binchunk.Prototype, pc, c int) string {
+	if c > 0xFF { /* is 'c' a constant? */
+		if s, ok := proto.Constants[c&0xFF].(string); ok {
+			return s /* literal constant, it is its own name */
+		}
+	} else { /* 'c' is a register */
+		if name, kind := getObjName(proto, pc, c); kind == "constant" {
+			return name /* found a constant name */
+		}
+	}
+	return "?" /* no reasonable name found */
+}
+
+// lua-5.3.4/src/ldebug.c#upvalname()
+func upvalName(proto *binchunk.Prototype, uv int) string {
+	if uv < len(proto.UpvalueNames) {
+		return proto.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+// lua-5.3.4/src/ldebug.c#filterpc()
+func filterPC(pc, jmpTarget int) int {
+	if pc < jmpTarget { /* is code conditional (inside a jump)? */
+		return -1 /* cannot know who sets that register */
+	}
+	return pc /* current position sets that register */
+}
+
+// finds the last instruction before lastpc that modified register reg
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(proto *binchunk.Prototype, lastpc, reg int) int {
+	setReg := -1   /* keep last instruction that changed 'reg' */
+	jmpTarget := 0 /* any code before this address is conditional */
+	for pc := 0; pc < lastpc; pc++ {
+		i := vm.Instruction(proto.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_JMP:
+			_, sbx := i.AsBx()
+			dest := pc + 1 + sbx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastpc && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmpTarget' */
+			}
+		default:
+			if i.TestAMode() && reg == a { /* any instruction that set A */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		}
+	}
+	return setReg
+}
+
+// type name of a value for error messages, its metatable may give
+// a '__name'
+// lua-5.3.4/src/ltm.c#luaT_objtypename()
+func (self *luaState) objTypeName(val luaValue) string {
+	if name, ok := getMetafield(val, "__name", self).(string); ok {
+		return name
+	}
+	return self.TypeName(typeOf(val))
+}
+
+// raises an error, adding the current line of the running function
+// to the message when it is a Lua function
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (self *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if c := self.stack.closure; c != nil && c.proto != nil { /* if Lua function, add source:line information */
+		src := "?"
+		if c.proto.Source != "" {
+			src = lexer.ChunkID(c.proto.Source)
+		}
+		msg = fmt.Sprintf("%s:%d: %s", src, self.stack.currentLine(), msg)
+	}
+	panic(msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_typeerror()
+func (self *luaState) valueTypeError(val luaValue, op string) {
+	t := self.objTypeName(val)
+	self.runError("attempt to %s a %s value%s", op, t, self.varInfo(val))
+}
+
+// describes the variable that holds val, an operand of the current
+// instruction of the running Lua function, as " (local 'x')"; values
+// are not addressed, so the operands of the instruction that hold val
+// take the place of the slot 'varinfo' looks for
+// lua-5.3.4/src/ldebug.c#varinfo()
+func (self *luaState) varInfo(val luaValue) string {
+	stack := self.stack
+	if stack.closure == nil || stack.closure.proto == nil { /* not a Lua function? */
+		return ""
+	}
+	proto := stack.closure.proto
+	pc := stack.pc - 1
+	if pc < 0 || pc >= len(proto.Code) {
+		return ""
+	}
+	var regs []int /* registers holding operands of the instruction */
+	i := vm.Instruction(proto.Code[pc])
+	a, b, c := i.ABC()
+	switch i.Opcode() {
+	case vm.OP_GETTABUP:
+		return upvalInfo(stack, b, val)
+	case vm.OP_SETTABUP:
+		return upvalInfo(stack, a, val)
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		regs = []int{b}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		regs = []int{a}
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		regs = []int{b, c} /* constants are skipped below */
+	case vm.OP_CONCAT: /* in the order 'luaV_concat' blames them */
+		regs = []int{c - 1, c}
+		for r := c - 2; r >= b; r-- {
+			regs = append(regs, r)
+		}
+	}
+	for _, reg := range regs {
+		if reg > 0xFF || reg >= len(stack.slots) { /* a constant? */
+			continue
+		}
+		if _eq(stack.slots[reg], val, nil) {
+			if name, kind := getObjName(proto, pc, reg); kind != "" {
+				return fmt.Sprintf(" (%s '%s')", kind, name)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// lua-5.3.4/src/ldebug.c#getupvalname()
+func upvalInfo(stack *luaStack, uv int, val luaValue) string {
+	c := stack.closure
+	if uv < len(c.upvals) && _eq(*c.upvals[uv].val, val, nil) {
+		return fmt.Sprintf(" (upvalue '%s')", upvalName(c.proto, uv))
+	}
+	return ""
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_concaterror()
+func (self *luaState) concatError(a, b luaValue) {
+	switch a.(type) {
+	case string, int64, float64:
+		a = b
+	}
+	self.valueTypeError(a, "concatenate")
+}
+
+// Error when both values are convertible to numbers, but not to integers
+// lua-5.3.4/src/ldebug.c#luaG_opinterror()
+func (self *luaState) opIntError(a, b luaValue, msg string) {
+	if _, ok := convertToFloat(a); ok { /* first operand is wrong? */
+		a = b /* now second is wrong too */
+	}
+	self.valueTypeError(a, msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_tointerror()
+func (self *luaState) toIntError(a, b luaValue) {
+	if _, ok := convertToInteger(a); !ok {
+		b = a
+	}
+	self.runError("number%s has no integer representation", self.varInfo(b))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_ordererror()
+func (self *luaState) orderError(a, b luaValue) {
+	t1 := self.objTypeName(a)
+	t2 := self.objTypeName(b)
+	if t1 == t2 {
+		self.runError("attempt to compare two %s values", t1)
+	} else {
+		self.runError("attempt to compare %s with %s", t1, t2)
+	}
+}
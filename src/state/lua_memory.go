@@ -0,0 +1,195 @@
+package state
+
+import "reflect"
+import "unsafe"
+import "github.com/tdkr/go-luavm/src/binchunk"
+
+/* rough sizes of the objects, in bytes */
+const (
+	sizeValue      = 16 // an interface value (stack slot, array item)
+	sizeString     = 16
+	sizeTable      = 64
+	sizeTableEntry = 40
+	sizeClosure    = 48
+	sizeUpvalue    = 24
+	sizeProto      = 160
+	sizeStack      = 96
+	sizeUserdata   = 48
+	sizeThread     = 64
+)
+
+// strings shorter than this are not deduplicated while measuring
+const minSharedString = 64
+
+// the objects are measured again only once the usage has grown
+// by 1/memGrowthDiv since the last measure, to bound its cost
+const memGrowthDiv = 4
+
+// message of memory errors
+const memErrMsg = "not enough memory"
+
+// raised when the memory limit is exceeded
+type memoryError struct{}
+
+func (self *memoryError) Error() string {
+	return memErrMsg
+}
+
+// accounts n more bytes, raising a memory error if they do not fit
+func (self *luaState) allocate(n int64) {
+	if self.g.memLimit > 0 {
+		self.reserve(n)
+		self.g.memUsed += n
+	}
+}
+
+// raises a memory error unless n more bytes fit under the limit,
+// the reachable objects are measured again before giving up if
+// enough has been allocated since the last measure or a memory
+// error was raised since
+func (self *luaState) reserve(n int64) {
+	g := self.g
+	if g.memLimit <= 0 || g.memUsed+n <= g.memLimit {
+		return
+	}
+	if g.memUsed-g.memBase >= g.memBase/memGrowthDiv {
+		self.remeasure()
+	}
+	if n > g.memLimit-g.memUsed {
+		g.memBase = 0 /* what the failed call built is garbage once unwound */
+		panic(&memoryError{})
+	}
+}
+
+// sets the memory in use to what is reachable now
+func (self *luaState) remeasure() {
+	self.g.memUsed = self.measure()
+	self.g.memBase = self.g.memUsed
+}
+
+// walks every object reachable from the registry and the running thread
+func (self *luaState) measure() int64 {
+	m := &memMeter{seen: map[interface{}]bool{}}
+	m.mark(self.registry)
+	m.mark(self)
+	for len(m.gray) > 0 {
+		obj := m.gray[len(m.gray)-1]
+		m.gray = m.gray[:len(m.gray)-1]
+		m.traverse(obj)
+	}
+	return m.total
+}
+
+type memMeter struct {
+	seen  map[interface{}]bool
+	gray  []interface{} // marked but not yet traversed
+	total int64
+}
+
+type stringKey struct {
+	data uintptr
+	len  int
+}
+
+func (self *memMeter) mark(val luaValue) {
+	switch x := val.(type) {
+	case string:
+		if len(x) >= minSharedString {
+			hdr := (*reflect.StringHeader)(unsafe.Pointer(&x))
+			key := stringKey{hdr.Data, hdr.Len}
+			if self.seen[key] {
+				return
+			}
+			self.seen[key] = true
+		}
+		self.total += sizeString + int64(len(x))
+	case *luaTable:
+		if x != nil {
+			self.markObject(x)
+		}
+	case *closure:
+		if x != nil {
+			self.markObject(x)
+		}
+	case *luaState:
+		if x != nil {
+			self.markObject(x)
+		}
+	case *userdata:
+		self.markObject(x)
+	}
+}
+
+func (self *memMeter) markObject(obj interface{}) {
+	if !self.seen[obj] {
+		self.seen[obj] = true
+		self.gray = append(self.gray, obj)
+	}
+}
+
+func (self *memMeter) traverse(obj interface{}) {
+	switch x := obj.(type) {
+	case *luaTable:
+		self.total += sizeTable
+		self.total += int64(cap(x.arr)) * sizeValue
+		self.total += int64(len(x._map)+len(x.keys)) * sizeTableEntry
+		self.mark(x.metatable)
+		for _, v := range x.arr {
+			self.mark(v)
+		}
+		for k, v := range x._map {
+			self.mark(k)
+			self.mark(v)
+		}
+	case *closure:
+		self.total += sizeClosure
+		self.proto(x.proto)
+		for _, uv := range x.upvals {
+			if uv != nil && !self.seen[uv] {
+				self.seen[uv] = true
+				self.total += sizeUpvalue
+				self.mark(*uv.val)
+			}
+		}
+	case *luaState:
+		self.total += sizeThread
+		for stack := x.stack; stack != nil; stack = stack.prev {
+			self.total += sizeStack
+			self.total += int64(len(stack.slots)+len(stack.varargs)+len(stack.saved)) * sizeValue
+			self.mark(stack.closure)
+			for _, v := range stack.slots {
+				self.mark(v)
+			}
+			for _, v := range stack.varargs {
+				self.mark(v)
+			}
+			for _, v := range stack.saved {
+				self.mark(v)
+			}
+		}
+		self.mark(x.errObj)
+	case *userdata:
+		self.total += sizeUserdata
+		self.mark(x.metatable)
+		self.mark(x.uservalue)
+	}
+}
+
+func (self *memMeter) proto(proto *binchunk.Prototype) {
+	if proto == nil || self.seen[proto] {
+		return
+	}
+	self.seen[proto] = true
+	self.total += sizeProto
+	self.total += int64(len(proto.Code)+len(proto.LineInfo)) * 4
+	self.total += int64(len(proto.Upvalues)) * 2
+	for _, k := range proto.Constants {
+		self.total += sizeValue
+		if s, ok := k.(string); ok {
+			self.total += int64(len(s))
+		}
+	}
+	for _, p := range proto.Protos {
+		self.proto(p)
+	}
+}
@@ -7,16 +7,27 @@ type luaStack struct {
 	slots []luaValue
 	top   int
 	/* call info */
-	state   *luaState
-	closure *closure
-	varargs []luaValue
-	openuvs map[int]*upvalue
-	pc      int
+	state    *luaState
+	closure  *closure
+	varargs  []luaValue
+	openuvs  map[int]*upvalue
+	pc       int
+	nResults int /* expected by the caller */
+	/* debug hooks */
+	isHook    bool /* frame of a running hook, not of a function */
+	hookYield bool /* a line or count hook yielded before pc */
+	leq       bool /* "<=" is being done as "not (b < a)" */
+	/* Go functions */
+	k     KFunction /* continuation after a yield */
+	ctx   KContext
+	pcall *pcallInfo /* set while a PCallK may be resumed */
+	saved []luaValue /* slots below the values yielded */
 	/* linked list */
 	prev *luaStack
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
+	state.allocate(sizeStack + int64(size)*sizeValue)
 	return &luaStack{
 		slots: make([]luaValue, size),
 		top:   0,
@@ -26,6 +37,9 @@ func newLuaStack(size int, state *luaState) *luaStack {
 
 func (self *luaStack) check(n int) {
 	free := len(self.slots) - self.top
+	if free < n {
+		self.state.allocate(int64(n-free) * sizeValue)
+	}
 	for i := free; i < n; i++ {
 		self.slots = append(self.slots, nil)
 	}
@@ -3,16 +3,24 @@ package state
 import . "github.com/tdkr/go-luavm/src/api"
 
 type luaState struct {
+	g        *globalState
 	registry *luaTable
 	stack    *luaStack
 	/* coroutine */
-	coStatus int
-	coCaller *luaState
-	coChan   chan int
+	status int
+	nny    int      /* number of non-yieldable calls in stack */
+	errObj luaValue /* error object of a dead coroutine */
+	/* debug hooks */
+	hook          LuaHook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	inHook        bool /* running a hook, other hooks are off */
+	oldPC         int  /* last traced instruction */
 }
 
 func New() LuaState {
-	ls := &luaState{}
+	ls := &luaState{g: &globalState{}, nny: 1}
 
 	registry := newLuaTable(8, 0)
 	registry.put(LUA_RIDX_MAINTHREAD, ls)
@@ -53,10 +53,10 @@ func _floatToInteger(key luaValue) luaValue {
 
 func (self *luaTable) put(key, val luaValue) {
 	if key == nil {
-		panic("table index is nil!")
+		panic("table index is nil")
 	}
 	if f, ok := key.(float64); ok && math.IsNaN(f) {
-		panic("table index is NaN!")
+		panic("table index is NaN")
 	}
 
 	self.changed = true
@@ -91,9 +91,10 @@ func (self *luaTable) put(key, val luaValue) {
 
 func (self *luaTable) _shrinkArray() {
 	for i := len(self.arr) - 1; i >= 0; i-- {
-		if self.arr[i] == nil {
-			self.arr = self.arr[0:i]
+		if self.arr[i] != nil {
+			break
 		}
+		self.arr = self.arr[0:i]
 	}
 }
 
@@ -0,0 +1,88 @@
+package state
+
+import "testing"
+
+func TestTableShrinkArray(t *testing.T) {
+	/* clearing the last element drops the trailing nils only */
+	tbl := newLuaTable(0, 0)
+	for i := int64(1); i <= 5; i++ {
+		tbl.put(i, i*10)
+	}
+	tbl.put(int64(1), nil)
+	tbl.put(int64(3), nil)
+	tbl.put(int64(5), nil)
+	if n := len(tbl.arr); n != 4 {
+		t.Errorf("array part has %d items, want 4", n)
+	}
+	for i, want := range []luaValue{nil, int64(20), nil, int64(40), nil} {
+		if got := tbl.get(int64(i + 1)); got != want {
+			t.Errorf("t[%d] = %v, want %v", i+1, got, want)
+		}
+	}
+
+	tbl.put(int64(4), nil)
+	tbl.put(int64(2), nil)
+	if n := len(tbl.arr); n != 0 {
+		t.Errorf("array part has %d items, want 0", n)
+	}
+}
+
+func TestRefReuse(t *testing.T) {
+	ls := New()
+	ls.NewTable()
+	ref := func(v string) int {
+		ls.PushString(v)
+		return ls.Ref(1)
+	}
+	get := func(r int) string {
+		ls.RawGetI(1, int64(r))
+		defer ls.Pop(1)
+		return ls.ToString(-1)
+	}
+
+	a, b, c, d := ref("a"), ref("b"), ref("c"), ref("d")
+	if a != 1 || b != 2 || c != 3 || d != 4 {
+		t.Fatalf("got refs %d %d %d %d", a, b, c, d)
+	}
+
+	/* holes in the array part: the first unref leaves t[1] nil */
+	ls.Unref(1, a)
+	ls.Unref(1, c)
+	if get(b) != "b" || get(d) != "d" {
+		t.Errorf("live refs lost: %q %q", get(b), get(d))
+	}
+	if r := ref("e"); r != c { /* last freed, first reused */
+		t.Errorf("got ref %d, want %d", r, c)
+	}
+	if r := ref("f"); r != a {
+		t.Errorf("got ref %d, want %d", r, a)
+	}
+	if r := ref("g"); r != 5 { /* free list is empty again */
+		t.Errorf("got ref %d, want 5", r)
+	}
+	for r, want := range map[int]string{1: "f", 2: "b", 3: "e", 4: "d", 5: "g"} {
+		if got := get(r); got != want {
+			t.Errorf("t[%d] = %q, want %q", r, got, want)
+		}
+	}
+
+	/* freeing the last refs */
+	ls.Unref(1, 5)
+	ls.Unref(1, 4)
+	if get(b) != "b" || get(1) != "f" {
+		t.Error("live refs lost after freeing the last ones")
+	}
+	if r := ref("h"); r != 4 {
+		t.Errorf("got ref %d, want 4", r)
+	}
+
+	ls.PushNil()
+	if r := ls.Ref(1); r != -1 {
+		t.Errorf("ref of nil: got %d, want LUA_REFNIL", r)
+	}
+	ls.Unref(1, -1) /* no-op */
+	ls.Unref(1, -2)
+	if ls.GetTop() != 1 {
+		t.Errorf("stack has %d values, want 1", ls.GetTop())
+	}
+}
@@ -0,0 +1,17 @@
+package state
+
+// full userdata
+type userdata struct {
+	metatable *luaTable
+	uservalue luaValue
+	data      interface{}
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
+
+// light userdata, compared by the pointer it wraps
+type lightUserdata struct {
+	ptr interface{}
+}
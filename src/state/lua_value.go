@@ -22,11 +22,25 @@ func typeOf(val luaValue) LuaType {
 		return LUA_TFUNCTION
 	case *luaState:
 		return LUA_TTHREAD
+	case *userdata:
+		return LUA_TUSERDATA
+	case lightUserdata:
+		return LUA_TLIGHTUSERDATA
 	default:
 		panic("todo!")
 	}
 }
 
+func isLuaValue(val interface{}) bool {
+	switch val.(type) {
+	case nil, bool, int64, float64, string, *luaTable, *closure,
+		*luaState, *userdata, lightUserdata:
+		return true
+	default:
+		return false
+	}
+}
+
 func convertToBoolean(val luaValue) bool {
 	switch x := val.(type) {
 	case nil:
@@ -79,8 +93,11 @@ func _stringToInteger(s string) (int64, bool) {
 /* metatable */
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -90,8 +107,12 @@ func getMetatable(val luaValue, ls *luaState) *luaTable {
 }
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		return
+	case *userdata:
+		x.metatable = mt
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
@@ -117,6 +138,6 @@ func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool)
 	ls.stack.push(mm)
 	ls.stack.push(a)
 	ls.stack.push(b)
-	ls.Call(2, 1)
+	ls.callTM(2, 1)
 	return ls.stack.pop(), true
 }
@@ -0,0 +1,88 @@
+package state
+
+import "errors"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+
+func TestMemoryLimit(t *testing.T) {
+	for _, chunk := range []string{
+		`local t = {} for i = 1, 1e7 do t[i] = i end`,
+		`local s = "x" while true do s = s .. s end`,
+		`local t = {} while true do t = {t} end`,
+		`local co = coroutine.wrap(function() local t = {} for i = 1, 1e7 do t[i] = {} end end) co()`,
+	} {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetMemoryLimit(1 << 20)
+		err := ls.DoStringE(chunk)
+		if !errors.Is(err, ErrMemoryLimit) {
+			t.Errorf("%s\ngot %v, want ErrMemoryLimit", chunk, err)
+			continue
+		}
+		if e := err.(*LuaError); e.Status != LUA_ERRMEM || e.Message != "not enough memory" {
+			t.Errorf("%s\ngot status %d, message %q", chunk, e.Status, e.Message)
+		}
+	}
+}
+
+func TestMemoryLimitPCall(t *testing.T) {
+	/* the garbage of the failed call is found again */
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemoryLimit(1 << 20)
+	err := ls.DoStringE(`
+		local function hog() local t = {} for i = 1, 1e7 do t[i] = {} end end
+		for i = 1, 5 do
+			local ok, msg = pcall(hog)
+			assert(not ok and msg == "not enough memory", msg)
+			ok, msg = pcall(coroutine.wrap(hog))
+			assert(not ok and msg == "not enough memory", msg)
+		end
+		local co = coroutine.create(hog)
+		local ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "not enough memory", msg)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryLimitGarbage(t *testing.T) {
+	/* allocating a lot of short-lived values stays under the limit */
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemoryLimit(1 << 20)
+	err := ls.DoStringE(`
+		for i = 1, 1000 do
+			local t = {}
+			for j = 1, 100 do t[j] = {j} end
+		end`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckMemory(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	before := ls.MemoryUsage()
+	ls.DoString(`t = {} for i = 1, 1000 do t[i] = {} end`)
+	if after := ls.MemoryUsage(); after <= before {
+		t.Errorf("usage went from %d to %d", before, after)
+	}
+
+	ls.SetMemoryLimit(ls.MemoryUsage() + 1000)
+	ls.Register("need", func(ls LuaState) int {
+		ls.CheckMemory(ls.CheckInteger(1))
+		return 0
+	})
+	if err := ls.DoStringE(`need(10)`); err != nil {
+		t.Errorf("need(10): %v", err)
+	}
+	if err := ls.DoStringE(`need(1e6)`); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("need(1e6): got %v", err)
+	}
+	ls.SetMemoryLimit(0)
+	if err := ls.DoStringE(`need(1e9)`); err != nil {
+		t.Errorf("without a limit: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package state
+
+import "strings"
+import "testing"
+import . "github.com/tdkr/go-luavm/src/api"
+
+/* available in every profile */
+var sandboxCommon = []string{
+	"print", "assert", "error", "pairs", "ipairs", "next", "select", "type",
+	"tostring", "tonumber", "pcall", "xpcall", "load", "setmetatable",
+	"rawequal", "rawlen", "coroutine.wrap", "math.floor", "string.format",
+	"string.rep", "table.concat", "utf8.char",
+}
+
+/* never available in a sandbox */
+var sandboxHidden = []string{
+	"io", "debug", "dofile", "loadfile", "string.dump", "collectgarbage",
+	"os.execute", "os.exit", "os.getenv", "os.remove", "os.rename",
+	"os.setlocale", "os.tmpname", "package.searchpath", `("").dump`,
+}
+
+func TestSandbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		sb      *Sandbox
+		present []string
+		absent  []string
+		refused map[string]string // chunk -> error message
+	}{
+		{"safe", SafeSandbox(),
+			[]string{"require", "getmetatable", "rawget", "rawset", "os.time",
+				"os.clock", "os.date", "package.preload", "package.searchers[1]"},
+			[]string{"package.searchers[2]"},
+			map[string]string{
+				`require("debug")`:  "module 'debug' not found",
+				`require("io")`:     "module 'io' not found",
+				`require("module")`: "module 'module' not found",
+			}},
+		{"strict", StrictSandbox(),
+			nil,
+			[]string{"os", "package", "require", "getmetatable", "rawget", "rawset"},
+			map[string]string{}},
+	}
+	for _, test := range tests {
+		ls := New()
+		ls.LoadString("local up = 1 return function() return up end")
+		ls.Call(0, 1)
+		ls.PushString(string(ls.Dump(false)))
+		ls.SetGlobal("binary")
+		ls.SetGlobal("hostfunc")
+		ls.OpenSandbox(test.sb)
+
+		for _, name := range append(sandboxCommon, test.present...) {
+			if !sandboxEval(t, ls, "return "+name+" ~= nil") {
+				t.Errorf("%s: %s is missing", test.name, name)
+			}
+		}
+		for _, name := range append(sandboxHidden, test.absent...) {
+			/* missing if nil or if its library is */
+			chunk := "local ok, v = pcall(function() return " + name + " end)\n" +
+				"return ok and v == nil or not ok and v:find('index a nil value') ~= nil"
+			if !sandboxEval(t, ls, chunk) {
+				t.Errorf("%s: %s is available", test.name, name)
+			}
+		}
+
+		/* binary chunks are refused, whatever mode is asked for */
+		refused := map[string]string{
+			`load(binary)`:            "attempt to load a binary chunk (mode is 't')",
+			`load(binary, "b", "b")`:  "attempt to load a binary chunk (mode is 't')",
+			`load(binary, "b", "bt")`: "attempt to load a binary chunk (mode is 't')",
+			`local s = binary
+			load(function() local r = s s = nil return r end, "b", "b")`: "attempt to load a binary chunk (mode is 't')",
+			`load("return 1", "t", "b")`: "attempt to load a text chunk (mode is 'b')",
+		}
+		for chunk, msg := range test.refused {
+			refused[chunk] = msg
+		}
+		for chunk, want := range refused {
+			if strings.HasPrefix(chunk, "load") || strings.HasPrefix(chunk, "local") {
+				chunk = strings.Replace(chunk, "load(", "return select(2, load(", 1) + ")"
+			} else {
+				chunk = "return select(2, pcall(function() " + chunk + " end))"
+			}
+			if err := ls.DoStringE(chunk); err != nil {
+				t.Errorf("%s: %s: %v", test.name, chunk, err)
+				continue
+			}
+			if msg := ls.ToString(-1); !strings.Contains(msg, want) {
+				t.Errorf("%s: %s\ngot %q, want %q", test.name, chunk, msg, want)
+			}
+			ls.SetTop(0)
+		}
+
+		/* the host cannot load binary chunks either */
+		ls.GetGlobal("binary")
+		if status := ls.Load([]byte(ls.ToString(-1)), "=bin", "bt"); status != LUA_ERRSYNTAX {
+			t.Errorf("%s: Load of a binary chunk: status %d", test.name, status)
+		}
+		ls.SetTop(0)
+
+		/* text chunks and functions from the host still work */
+		if !sandboxEval(t, ls, `return load("return hostfunc() + 1")() == 2`) {
+			t.Errorf("%s: text chunks do not work", test.name)
+		}
+	}
+}
+
+func TestSandboxFileAccess(t *testing.T) {
+	ls := New()
+	ls.OpenSandbox(&Sandbox{Libs: []string{"_G", "package"}, FileAccess: true})
+	for _, name := range []string{"dofile", "loadfile", "package.searchpath", "package.searchers[2]"} {
+		if !sandboxEval(t, ls, "return "+name+" ~= nil") {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	if !sandboxEval(t, ls, "return string == nil and os == nil") {
+		t.Error("libraries that were not listed are open")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("unknown library accepted")
+		}
+	}()
+	New().OpenSandbox(&Sandbox{Libs: []string{"nope"}})
+}
+
+// runs chunk, which must return a boolean
+func sandboxEval(t *testing.T, ls LuaState, chunk string) bool {
+	if err := ls.DoStringE(chunk); err != nil {
+		t.Errorf("%s: %v", chunk, err)
+		return false
+	}
+	ok := ls.ToBoolean(-1)
+	ls.SetTop(0)
+	return ok
+}
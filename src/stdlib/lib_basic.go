@@ -1,6 +1,8 @@
 package stdlib
 
+import "errors"
 import "fmt"
+import "io"
 import "strconv"
 import "strings"
 import . "github.com/tdkr/go-luavm/src/api"
@@ -50,26 +52,41 @@ func OpenBaseLib(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-print
 // lua-5.3.4/src/lbaselib.c#luaB_print()
 func basePrint(ls LuaState) int {
-	n := ls.GetTop() /* number of arguments */
 	ls.GetGlobal("tostring")
-	for i := 1; i <= n; i++ {
+	return printArgs(ls, 1)
+}
+
+// prints the arguments from i on, 'tostring' is on the top
+// of the stack and may yield
+func printArgs(ls LuaState, i int) int {
+	n := ls.GetTop() - 1 /* number of arguments */
+	for ; i <= n; i++ {
 		ls.PushValue(-1) /* function to be called */
 		ls.PushValue(i)  /* value to print */
-		ls.Call(1, 1)
-		s, ok := ls.ToStringX(-1) /* get result */
-		if !ok {
-			return ls.Error2("'tostring' must return a string to 'print'")
-		}
-		if i > 1 {
-			fmt.Print("\t")
-		}
-		fmt.Print(s)
-		ls.Pop(1) /* pop result */
+		ls.CallK(1, 1, KContext(i), finishPrint)
+		printResult(ls, i)
 	}
 	fmt.Println()
 	return 0
 }
 
+func finishPrint(ls LuaState, status int, ctx KContext) int {
+	printResult(ls, int(ctx))
+	return printArgs(ls, int(ctx)+1)
+}
+
+func printResult(ls LuaState, i int) {
+	s, ok := ls.ToStringX(-1) /* get result */
+	if !ok {
+		ls.Error2("'tostring' must return a string to 'print'")
+	}
+	if i > 1 {
+		fmt.Print("\t")
+	}
+	fmt.Print(s)
+	ls.Pop(1) /* pop result */
+}
+
 // assert (v [, message])
 // http://www.lua.org/manual/5.3/manual.html#pdf-assert
 // lua-5.3.4/src/lbaselib.c#luaB_assert()
@@ -92,9 +109,9 @@ func baseError(ls LuaState) int {
 	level := int(ls.OptInteger(2, 1))
 	ls.SetTop(1)
 	if ls.Type(1) == LUA_TSTRING && level > 0 {
-		// ls.Where(level) /* add extra information */
-		// ls.PushValue(1)
-		// ls.Concat(2)
+		ls.Where(level) /* add extra information */
+		ls.PushValue(1)
+		ls.Concat(2)
 	}
 	return ls.Error()
 }
@@ -185,16 +202,56 @@ func baseLoad(ls LuaState) int {
 		chunkname := ls.OptString(2, chunk)
 		status = ls.Load([]byte(chunk), chunkname, mode)
 	} else { /* loading from a reader function */
-		panic("loading from a reader function") // todo
+		chunkname := ls.OptString(2, "=(load)")
+		ls.CheckType(1, LUA_TFUNCTION)
+		status = ls.LoadReader(&funcReader{ls: ls}, chunkname, mode)
 	}
 	return loadAux(ls, status, env)
 }
 
+// lua-5.3.4/src/lbaselib.c#generic_reader()
+// reads the pieces returned by the function at index 1
+type funcReader struct {
+	ls  LuaState
+	buf string
+}
+
+func (self *funcReader) Read(p []byte) (int, error) {
+	ls := self.ls
+	for self.buf == "" {
+		ls.CheckStack2(2, "too many nested functions")
+		ls.PushValue(1) /* get function */
+		if ls.PCall(0, 1, 0) != LUA_OK {
+			err := errors.New(ls.ToString2(-1))
+			ls.Pop(2) /* pop message and error object */
+			return 0, err
+		}
+		if ls.IsNil(-1) {
+			ls.Pop(1) /* pop result */
+			return 0, io.EOF
+		} else if !ls.IsString(-1) {
+			ls.Pop(1)
+			return 0, errors.New("reader function must return a string")
+		}
+		self.buf = ls.ToString(-1)
+		ls.Pop(1)
+		if self.buf == "" { /* empty string also ends the chunk */
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, self.buf)
+	self.buf = self.buf[n:]
+	return n, nil
+}
+
 // lua-5.3.4/src/lbaselib.c#load_aux()
 func loadAux(ls LuaState, status, envIdx int) int {
 	if status == LUA_OK {
 		if envIdx != 0 { /* 'env' parameter? */
-			panic("todo!")
+			ls.PushValue(envIdx)
+			if _, ok := ls.SetUpvalue(-2, 1); !ok { /* set it as 1st upvalue */
+				ls.Pop(1) /* remove 'env' if not used by previous call */
+			}
 		}
 		return 1
 	} else { /* error (message is on top of the stack) */
@@ -209,7 +266,7 @@ func loadAux(ls LuaState, status, envIdx int) int {
 // lua-5.3.4/src/lbaselib.c#luaB_loadfile()
 func baseLoadFile(ls LuaState) int {
 	fname := ls.OptString(1, "")
-	mode := ls.OptString(1, "bt")
+	mode := ls.OptString(2, "bt")
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(3) {
 		env = 3
@@ -227,24 +284,49 @@ func baseDoFile(ls LuaState) int {
 	if ls.LoadFile(fname) != LUA_OK {
 		return ls.Error()
 	}
-	ls.Call(0, LUA_MULTRET)
+	ls.CallK(0, LUA_MULTRET, 0, doFileCont)
+	return doFileCont(ls, 0, 0)
+}
+
+// lua-5.3.4/src/lbaselib.c#dofilecont()
+func doFileCont(ls LuaState, status int, ctx KContext) int {
 	return ls.GetTop() - 1
 }
 
 // pcall (f [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+// lua-5.3.4/src/lbaselib.c#luaB_pcall()
 func basePCall(ls LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, LUA_MULTRET, 0, 0, finishPCall)
+	return finishPCall(ls, status, 0)
 }
 
 // xpcall (f, msgh [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
 func baseXPCall(ls LuaState) int {
-	panic("todo!")
+	n := ls.GetTop()
+	ls.CheckType(2, LUA_TFUNCTION) /* check error function */
+	ls.PushBoolean(true)           /* first result */
+	ls.PushValue(1)                /* function */
+	ls.Rotate(3, 2)                /* move them below function's arguments */
+	status := ls.PCallK(n-2, LUA_MULTRET, 2, 2, finishPCall)
+	return finishPCall(ls, status, 2)
+}
+
+// continuation of pcall and xpcall, extra is the
+// number of values below the 'true' result
+// lua-5.3.4/src/lbaselib.c#finishpcall()
+func finishPCall(ls LuaState, status int, extra KContext) int {
+	if status != LUA_OK && status != LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - int(extra) /* return all results */
 }
 
 // getmetatable (object)
@@ -336,10 +418,22 @@ func baseType(ls LuaState) int {
 // lua-5.3.4/src/lbaselib.c#luaB_tostring()
 func baseToString(ls LuaState) int {
 	ls.CheckAny(1)
+	if ls.GetMetafield(1, "__tostring") != LUA_TNIL { /* metafield? */
+		ls.PushValue(1)
+		ls.CallK(1, 1, 0, finishToString) /* '__tostring' may yield */
+		return finishToString(ls, LUA_OK, 0)
+	}
 	ls.ToString2(1)
 	return 1
 }
 
+func finishToString(ls LuaState, status int, ctx KContext) int {
+	if !ls.IsString(-1) {
+		ls.Error2("'__tostring' must return a string")
+	}
+	return 1
+}
+
 // tonumber (e [, base])
 // http://www.lua.org/manual/5.3/manual.html#pdf-tonumber
 // lua-5.3.4/src/lbaselib.c#luaB_tonumber()
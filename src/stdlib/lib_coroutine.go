@@ -10,6 +10,24 @@ var coFuncs = map[string]GoFunction{
 	"isyieldable": coYieldable,
 	"running":     coRunning,
 	"wrap":        coWrap,
+	"close":       coClose,
+}
+
+/* coroutine statuses */
+const (
+	_COS_RUN   = iota /* running */
+	_COS_DEAD         /* dead */
+	_COS_YIELD        /* suspended */
+	_COS_NORM         /* 'normal' (it resumed another coroutine) */
+)
+
+var statName = []string{"running", "dead", "suspended", "normal"}
+
+// lua-5.3.4/src/lcorolib.c#getco()
+func getCo(ls LuaState) LuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
 }
 
 func OpenCoroutineLib(ls LuaState) int {
@@ -32,9 +50,7 @@ func coCreate(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
 // lua-5.3.4/src/lcorolib.c#luaB_coresume()
 func coResume(ls LuaState) int {
-	co := ls.ToThread(1)
-	ls.ArgCheck(co != nil, 1, "thread expected")
-
+	co := getCo(ls)
 	if r := _auxResume(ls, co, ls.GetTop()-1); r < 0 {
 		ls.PushBoolean(false)
 		ls.Insert(-2)
@@ -47,7 +63,7 @@ func coResume(ls LuaState) int {
 }
 
 func _auxResume(ls, co LuaState, narg int) int {
-	if !ls.CheckStack(narg) {
+	if !co.CheckStack(narg) {
 		ls.PushString("too many arguments to resume")
 		return -1 /* error flag */
 	}
@@ -83,28 +99,31 @@ func coYield(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
 // lua-5.3.4/src/lcorolib.c#luaB_costatus()
 func coStatus(ls LuaState) int {
-	co := ls.ToThread(1)
-	ls.ArgCheck(co != nil, 1, "thread expected")
+	co := getCo(ls)
+	ls.PushString(statName[_auxStatus(ls, co)])
+	return 1
+}
+
+// lua-5.4.6/src/lcorolib.c#auxstatus()
+func _auxStatus(ls, co LuaState) int {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case LUA_YIELD:
-			ls.PushString("suspended")
-		case LUA_OK:
-			if co.GetStack() { /* does it have frames? */
-				ls.PushString("normal") /* it is running */
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default: /* some error occurred */
-			ls.PushString("dead")
+		return _COS_RUN
+	}
+	switch co.Status() {
+	case LUA_YIELD:
+		return _COS_YIELD
+	case LUA_OK:
+		var ar LuaDebug
+		if co.GetStack(0, &ar) { /* does it have frames? */
+			return _COS_NORM /* it is running */
+		} else if co.GetTop() == 0 {
+			return _COS_DEAD
+		} else {
+			return _COS_YIELD /* initial state */
 		}
+	default: /* some error occurred */
+		return _COS_DEAD
 	}
-
-	return 1
 }
 
 // coroutine.isyieldable ()
@@ -124,6 +143,45 @@ func coRunning(ls LuaState) int {
 
 // coroutine.wrap (f)
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
 func coWrap(ls LuaState) int {
-	panic("todo: coWrap!")
+	coCreate(ls)
+	ls.PushGoClosure(_auxWrap, 1)
+	return 1
+}
+
+// lua-5.4.6/src/lcorolib.c#auxwrap()
+func _auxWrap(ls LuaState) int {
+	co := ls.ToThread(LuaUpvalueIndex(1))
+	r := _auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		if co.Status() != LUA_ERRMEM && /* not a memory error and ... */
+			ls.Type(-1) == LUA_TSTRING { /* ... error object is a string? */
+			ls.Where(1) /* get extra info */
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		return ls.Error() /* propagate error */
+	}
+	return r
+}
+
+// coroutine.close (co)
+// http://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+// lua-5.4.6/src/lcorolib.c#luaB_close()
+func coClose(ls LuaState) int {
+	co := getCo(ls)
+	switch status := _auxStatus(ls, co); status {
+	case _COS_DEAD, _COS_YIELD:
+		if co.CloseThread(ls) == LUA_OK {
+			ls.PushBoolean(true)
+			return 1
+		} else {
+			ls.PushBoolean(false)
+			co.XMove(ls, 1) /* move error message */
+			return 2
+		}
+	default: /* normal or running coroutine */
+		return ls.Error2("cannot close a %s coroutine", statName[status])
+	}
 }
@@ -0,0 +1,447 @@
+package stdlib
+
+import "reflect"
+import "strings"
+import . "github.com/tdkr/go-luavm/src/api"
+
+var dbFuncs = map[string]GoFunction{
+	"getuservalue": dbGetUserValue,
+	"gethook":      dbGetHook,
+	"getinfo":      dbGetInfo,
+	"getlocal":     dbGetLocal,
+	"getregistry":  dbGetRegistry,
+	"getmetatable": dbGetMetatable,
+	"getupvalue":   dbGetUpvalue,
+	"upvaluejoin":  dbUpvalueJoin,
+	"upvalueid":    dbUpvalueID,
+	"setuservalue": dbSetUserValue,
+	"sethook":      dbSetHook,
+	"setlocal":     dbSetLocal,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+/* key, in the registry, for table of hooks */
+const _HOOKKEY = "_HKEY"
+
+func OpenDebugLib(ls LuaState) int {
+	ls.NewLib(dbFuncs)
+	return 1
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+func dbGetRegistry(ls LuaState) int {
+	ls.PushValue(LUA_REGISTRYINDEX)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+// lua-5.3.4/src/ldblib.c#db_getmetatable()
+func dbGetMetatable(ls LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil() /* no metatable */
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+// lua-5.3.4/src/ldblib.c#db_setmetatable()
+func dbSetMetatable(ls LuaState) int {
+	t := ls.Type(2)
+	ls.ArgCheck(t == LUA_TNIL || t == LUA_TTABLE, 2, "nil or table expected")
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1 /* return 1st argument */
+}
+
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+// lua-5.3.4/src/ldblib.c#db_getuservalue()
+func dbGetUserValue(ls LuaState) int {
+	if ls.Type(1) != LUA_TUSERDATA {
+		ls.PushNil()
+	} else {
+		ls.GetUserValue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+// lua-5.3.4/src/ldblib.c#db_setuservalue()
+func dbSetUserValue(ls LuaState) int {
+	ls.CheckType(1, LUA_TUSERDATA)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.SetUserValue(1)
+	return 1
+}
+
+// If L1 != L, L1 can be in any state, and therefore there are no
+// guarantees about its stack space; any push in L1 must be
+// checked.
+// lua-5.3.4/src/ldblib.c#checkstack()
+func _checkStack(ls, L1 LuaState, n int) {
+	if ls != L1 && !L1.CheckStack(n) {
+		ls.Error2("stack overflow")
+	}
+}
+
+// Auxiliary function used by several library functions: check for
+// an optional thread as function's first argument and set 'arg' with
+// 1 if this argument is present (so that functions can skip it to
+// access their other arguments)
+// lua-5.3.4/src/ldblib.c#getthread()
+func _getThread(ls LuaState) (LuaState, int) {
+	if ls.IsThread(1) {
+		return ls.ToThread(1), 1
+	}
+	return ls, 0 /* function will operate over current thread */
+}
+
+// Variations of 'lua_settable', used by 'db_getinfo' to put results
+// from 'lua_getinfo' into result table. Key is always a string;
+// value can be a string, an int, or a boolean.
+func _setTabSS(ls LuaState, k, v string) {
+	ls.PushString(v)
+	ls.SetField(-2, k)
+}
+
+func _setTabSI(ls LuaState, k string, v int) {
+	ls.PushInteger(int64(v))
+	ls.SetField(-2, k)
+}
+
+func _setTabSB(ls LuaState, k string, v bool) {
+	ls.PushBoolean(v)
+	ls.SetField(-2, k)
+}
+
+// In function 'db_getinfo', the call to 'lua_getinfo' may push
+// results on the stack; later it creates the result table to put
+// these objects. Function 'treatstackoption' puts the result from
+// 'lua_getinfo' on top of the result table so that it can call
+// 'lua_setfield'.
+// lua-5.3.4/src/ldblib.c#treatstackoption()
+func _treatStackOption(ls, L1 LuaState, fname string) {
+	if ls == L1 {
+		ls.Rotate(-2, 1) /* exchange object and table */
+	} else {
+		L1.XMove(ls, 1) /* move object to the "main" stack */
+	}
+	ls.SetField(-2, fname) /* put object into table */
+}
+
+// debug.getinfo ([thread,] f [, what])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+// lua-5.3.4/src/ldblib.c#db_getinfo()
+func dbGetInfo(ls LuaState) int {
+	var ar LuaDebug
+	L1, arg := _getThread(ls)
+	options := ls.OptString(arg+2, "flnStu")
+	_checkStack(ls, L1, 3)
+	if ls.IsFunction(arg + 1) { /* info about a function? */
+		options = ">" + options /* add '>' to 'options' */
+		ls.PushValue(arg + 1)   /* move function to 'L1' stack */
+		ls.XMove(L1, 1)
+	} else { /* stack level */
+		if !L1.GetStack(int(ls.CheckInteger(arg+1)), &ar) {
+			ls.PushNil() /* level out of range */
+			return 1
+		}
+	}
+	if !L1.GetInfo(options, &ar) {
+		return ls.ArgError(arg+2, "invalid option")
+	}
+	ls.NewTable() /* table to collect results */
+	if strings.IndexByte(options, 'S') >= 0 {
+		_setTabSS(ls, "source", ar.Source)
+		_setTabSS(ls, "short_src", ar.ShortSrc)
+		_setTabSI(ls, "linedefined", ar.LineDefined)
+		_setTabSI(ls, "lastlinedefined", ar.LastLineDefined)
+		_setTabSS(ls, "what", ar.What)
+	}
+	if strings.IndexByte(options, 'l') >= 0 {
+		_setTabSI(ls, "currentline", ar.CurrentLine)
+	}
+	if strings.IndexByte(options, 'u') >= 0 {
+		_setTabSI(ls, "nups", ar.NUps)
+		_setTabSI(ls, "nparams", ar.NParams)
+		_setTabSB(ls, "isvararg", ar.IsVararg)
+	}
+	if strings.IndexByte(options, 'n') >= 0 {
+		if ar.Name != "" {
+			_setTabSS(ls, "name", ar.Name)
+		}
+		_setTabSS(ls, "namewhat", ar.NameWhat)
+	}
+	if strings.IndexByte(options, 't') >= 0 {
+		_setTabSB(ls, "istailcall", ar.IsTailCall)
+	}
+	if strings.IndexByte(options, 'L') >= 0 {
+		_treatStackOption(ls, L1, "activelines")
+	}
+	if strings.IndexByte(options, 'f') >= 0 {
+		_treatStackOption(ls, L1, "func")
+	}
+	return 1 /* return table */
+}
+
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
+func dbGetLocal(ls LuaState) int {
+	var ar LuaDebug
+	L1, arg := _getThread(ls)
+	nvar := int(ls.CheckInteger(arg + 2)) /* local-variable index */
+	if ls.IsFunction(arg + 1) {           /* function argument? */
+		ls.PushValue(arg + 1) /* push function */
+		if name, ok := ls.GetLocal(nil, nvar); ok {
+			ls.PushString(name) /* push local name */
+		} else {
+			ls.PushNil()
+		}
+		return 1 /* return only name (there is no value) */
+	}
+
+	/* stack-level argument */
+	level := int(ls.CheckInteger(arg + 1))
+	if !L1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	_checkStack(ls, L1, 1)
+	if name, ok := L1.GetLocal(&ar, nvar); ok {
+		L1.XMove(ls, 1)     /* move local value */
+		ls.PushString(name) /* push name */
+		ls.Rotate(-2, 1)    /* re-order */
+		return 2
+	}
+	ls.PushNil() /* no name (nor value) */
+	return 1
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
+func dbSetLocal(ls LuaState) int {
+	var ar LuaDebug
+	L1, arg := _getThread(ls)
+	level := int(ls.CheckInteger(arg + 1))
+	nvar := int(ls.CheckInteger(arg + 2))
+	if !L1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+	_checkStack(ls, L1, 1)
+	ls.XMove(L1, 1)
+	if name, ok := L1.SetLocal(&ar, nvar); ok {
+		ls.PushString(name)
+	} else {
+		L1.Pop(1) /* pop value (if not popped by 'lua_setlocal') */
+		ls.PushNil()
+	}
+	return 1
+}
+
+// get (if 'get' is true) or set an upvalue from a closure
+// lua-5.3.4/src/ldblib.c#auxupvalue()
+func _auxUpvalue(ls LuaState, get bool) int {
+	n := int(ls.CheckInteger(2))   /* upvalue index */
+	ls.CheckType(1, LUA_TFUNCTION) /* closure */
+	if get {
+		name, ok := ls.GetUpvalue(1, n)
+		if !ok {
+			return 0
+		}
+		ls.PushString(name)
+		ls.Insert(-2)
+		return 2
+	}
+	name, ok := ls.SetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+func dbGetUpvalue(ls LuaState) int {
+	return _auxUpvalue(ls, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+func dbSetUpvalue(ls LuaState) int {
+	ls.CheckAny(3)
+	return _auxUpvalue(ls, false)
+}
+
+// Check whether a given upvalue from a given closure exists and
+// returns its index
+// lua-5.3.4/src/ldblib.c#checkupval()
+func _checkUpval(ls LuaState, argf, argnup int) int {
+	nup := int(ls.CheckInteger(argnup)) /* upvalue index */
+	ls.CheckType(argf, LUA_TFUNCTION)   /* closure */
+	_, ok := ls.GetUpvalue(argf, nup)
+	ls.ArgCheck(ok, argnup, "invalid upvalue index")
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+// lua-5.3.4/src/ldblib.c#db_upvalueid()
+func dbUpvalueID(ls LuaState) int {
+	n := _checkUpval(ls, 1, 2)
+	ls.PushLightUserdata(ls.UpvalueID(1, n))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+// lua-5.3.4/src/ldblib.c#db_upvaluejoin()
+func dbUpvalueJoin(ls LuaState) int {
+	n1 := _checkUpval(ls, 1, 2)
+	n2 := _checkUpval(ls, 3, 4)
+	ls.ArgCheck(!ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.ArgCheck(!ls.IsGoFunction(3), 3, "Lua function expected")
+	ls.UpvalueJoin(1, n1, 3, n2)
+	return 0
+}
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+// Call hook function registered at hook table for the current
+// thread (if there is one)
+// lua-5.3.4/src/ldblib.c#hookf()
+func hookF(ls LuaState, ar *LuaDebug) {
+	ls.GetField(LUA_REGISTRYINDEX, _HOOKKEY)
+	ls.PushThread()
+	if ls.RawGet(-2) == LUA_TFUNCTION { /* is there a hook function? */
+		ls.PushString(hookNames[ar.Event]) /* push event name */
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine)) /* push current line */
+		} else {
+			ls.PushNil()
+		}
+		ls.Call(2, 0) /* call hook function */
+	}
+}
+
+// Convert a string mask (for 'sethook') into a bit mask
+// lua-5.3.4/src/ldblib.c#makemask()
+func _makeMask(smask string, count int) int {
+	mask := 0
+	if strings.IndexByte(smask, 'c') >= 0 {
+		mask |= LUA_MASKCALL
+	}
+	if strings.IndexByte(smask, 'r') >= 0 {
+		mask |= LUA_MASKRET
+	}
+	if strings.IndexByte(smask, 'l') >= 0 {
+		mask |= LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= LUA_MASKCOUNT
+	}
+	return mask
+}
+
+// Convert a bit mask (for 'gethook') into a string mask
+// lua-5.3.4/src/ldblib.c#unmakemask()
+func _unmakeMask(mask int) string {
+	smask := ""
+	if mask&LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbSetHook(ls LuaState) int {
+	var mask, count int
+	var fn LuaHook
+	L1, arg := _getThread(ls)
+	if ls.IsNoneOrNil(arg + 1) { /* no hook? */
+		ls.SetTop(arg + 1)
+		fn = nil /* turn off hooks */
+	} else {
+		smask := ls.CheckString(arg + 2)
+		ls.CheckType(arg+1, LUA_TFUNCTION)
+		count = int(ls.OptInteger(arg+3, 0))
+		fn = hookF
+		mask = _makeMask(smask, count)
+	}
+	if !ls.GetSubTable(LUA_REGISTRYINDEX, _HOOKKEY) {
+		ls.PushString("k")
+		ls.SetField(-2, "__mode") /** hooktable.__mode = "k" */
+		ls.PushValue(-1)
+		ls.SetMetatable(-2) /* setmetatable(hooktable) = hooktable */
+	}
+	_checkStack(ls, L1, 1)
+	L1.PushThread()
+	L1.XMove(ls, 1)       /* key (thread) */
+	ls.PushValue(arg + 1) /* value (hook function) */
+	ls.RawSet(-3)         /* hooktable[L1] = new Lua hook */
+	L1.SetHook(fn, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbGetHook(ls LuaState) int {
+	L1, _ := _getThread(ls)
+	mask := L1.GetHookMask()
+	hook := L1.GetHook()
+	if hook == nil { /* no hook? */
+		ls.PushNil()
+	} else if reflect.ValueOf(hook).Pointer() != reflect.ValueOf(hookF).Pointer() {
+		ls.PushString("external hook") /* external hook? */
+	} else { /* hook table must exist */
+		ls.GetField(LUA_REGISTRYINDEX, _HOOKKEY)
+		_checkStack(ls, L1, 1)
+		L1.PushThread()
+		L1.XMove(ls, 1)
+		ls.RawGet(-2) /* 1st result = hooktable[L1] */
+		ls.Remove(-2) /* remove hook table */
+	}
+	ls.PushString(_unmakeMask(mask))         /* 2nd result = mask */
+	ls.PushInteger(int64(L1.GetHookCount())) /* 3rd result = count */
+	return 3
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
+func dbTraceback(ls LuaState) int {
+	L1, arg := _getThread(ls)
+	if ls.Type(arg+1) != LUA_TSTRING && ls.Type(arg+1) != LUA_TNUMBER &&
+		!ls.IsNoneOrNil(arg+1) { /* non-string 'msg'? */
+		ls.PushValue(arg + 1) /* return it untouched */
+		return 1
+	}
+	msg := ls.ToString(arg + 1)
+	level := 0
+	if ls == L1 {
+		level = 1
+	}
+	level = int(ls.OptInteger(arg+2, int64(level)))
+	ls.PushString(ls.Traceback(L1, msg, level))
+	return 1
+}
@@ -0,0 +1,874 @@
+package stdlib
+
+import "bufio"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "os/exec"
+import "runtime"
+import "strings"
+import "syscall"
+import . "github.com/tdkr/go-luavm/src/api"
+
+/* metatable name of file handles */
+const LUA_FILEHANDLE = "FILE*"
+
+/* keys, in the registry, of the default input and output files */
+const (
+	_IO_PREFIX = "_IO_"
+	_IO_INPUT  = _IO_PREFIX + "input"
+	_IO_OUTPUT = _IO_PREFIX + "output"
+)
+
+/* maximum number of arguments to 'f:lines'/'io.lines' (it + 3 must fit in a Lua upvalue index) */
+const _MAXARGLINE = 250
+
+/* maximum length of a numeral */
+const _L_MAXLENNUM = 200
+
+const LUAL_BUFFERSIZE = 4096
+
+/* buffering modes of a stream, as in 'setvbuf' */
+const (
+	_IOFBF = iota /* full buffering */
+	_IOLBF        /* line buffering */
+	_IONBF        /* no buffering */
+)
+
+var ioLib = map[string]GoFunction{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"popen":   ioPopen,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+/* methods for file handles */
+var fLib = map[string]GoFunction{
+	"close":      ioClose,
+	"flush":      fFlush,
+	"lines":      fLines,
+	"read":       fRead,
+	"seek":       fSeek,
+	"setvbuf":    fSetVBuf,
+	"write":      fWrite,
+	"__gc":       fGC,
+	"__close":    fGC,
+	"__tostring": fToString,
+}
+
+func OpenIOLib(ls LuaState) int {
+	ls.NewLib(ioLib) /* new module */
+	_createMeta(ls)
+	/* create (and set) default files */
+	_createStdFile(ls, os.Stdin, _IO_INPUT, "stdin", _IOFBF)
+	_createStdFile(ls, os.Stdout, _IO_OUTPUT, "stdout", _IONBF)
+	_createStdFile(ls, os.Stderr, "", "stderr", _IONBF)
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#createmeta()
+func _createMeta(ls LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.PushValue(-1)                /* push metatable */
+	ls.SetField(-2, "__index")      /* metatable.__index = metatable */
+	ls.SetFuncs(fLib, 0)            /* add file methods to new metatable */
+	ls.Pop(1)                       /* pop new metatable */
+}
+
+// lua-5.3.4/src/liolib.c#createstdfile()
+func _createStdFile(ls LuaState, f *os.File, k, fname string, vbuf int) {
+	p := _newPreFile(ls)
+	p.file = f
+	p.vbuf = vbuf
+	p.closeF = ioNoClose
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(LUA_REGISTRYINDEX, k) /* add file to registry */
+	}
+	ls.SetField(-2, fname) /* add file to module */
+}
+
+/* the data of a file handle, a port of luaL_Stream */
+type luaStream struct {
+	file   *os.File
+	rd     *bufio.Reader
+	wr     *bufio.Writer
+	vbuf   int        /* buffering mode */
+	bufSz  int        /* size of the write buffer */
+	closeF GoFunction /* to close stream (nil for closed streams) */
+	cmd    *exec.Cmd  /* process of a stream opened by 'io.popen' */
+	tmp    bool       /* remove the file when closing it */
+}
+
+func (self *luaStream) isClosed() bool {
+	return self.closeF == nil
+}
+
+func (self *luaStream) reader() *bufio.Reader {
+	if self.wr != nil {
+		self.wr.Flush()
+	}
+	if self.rd == nil {
+		self.rd = bufio.NewReader(self.file)
+	}
+	return self.rd
+}
+
+func (self *luaStream) writer() *bufio.Writer {
+	if self.rd != nil && self.rd.Buffered() > 0 {
+		/* give back the input read ahead, if the file can seek */
+		back := int64(-self.rd.Buffered())
+		if _, err := self.file.Seek(back, io.SeekCurrent); err == nil {
+			self.rd.Reset(self.file)
+		}
+	}
+	if self.wr == nil {
+		size := self.bufSz
+		if size <= 0 {
+			size = LUAL_BUFFERSIZE
+		}
+		self.wr = bufio.NewWriterSize(self.file, size)
+	}
+	return self.wr
+}
+
+func (self *luaStream) write(s string) error {
+	wr := self.writer()
+	if _, err := wr.WriteString(s); err != nil {
+		return err
+	}
+	if self.vbuf == _IONBF ||
+		self.vbuf == _IOLBF && strings.IndexByte(s, '\n') >= 0 {
+		return wr.Flush()
+	}
+	return nil
+}
+
+func (self *luaStream) flush() error {
+	if self.wr != nil {
+		return self.wr.Flush()
+	}
+	return nil
+}
+
+func (self *luaStream) seek(offset int64, whence int) (int64, error) {
+	if err := self.flush(); err != nil {
+		return 0, err
+	}
+	if self.rd != nil && whence == io.SeekCurrent {
+		offset -= int64(self.rd.Buffered()) /* not read by Lua yet */
+	}
+	pos, err := self.file.Seek(offset, whence)
+	if err == nil && self.rd != nil {
+		self.rd.Reset(self.file)
+	}
+	return pos, err
+}
+
+func (self *luaStream) close() error {
+	err := self.flush()
+	if e := self.file.Close(); err == nil {
+		err = e
+	}
+	if self.tmp {
+		if e := os.Remove(self.file.Name()); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/* streams lost without being closed still flush their output */
+func (self *luaStream) finalize() {
+	if !self.isClosed() {
+		self.close()
+	}
+}
+
+// lua-5.3.4/src/liolib.c#tolstream()
+func _toStream(ls LuaState) *luaStream {
+	return ls.CheckUdata(1, LUA_FILEHANDLE).(*luaStream)
+}
+
+// lua-5.3.4/src/liolib.c#tofile()
+func _toFile(ls LuaState) *luaStream {
+	p := _toStream(ls)
+	if p.isClosed() {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+// When creating file handles, always creates a 'closed' file handle
+// before opening the actual file; so, if there is a memory error, the
+// handle is in a consistent state.
+// lua-5.3.4/src/liolib.c#newprefile()
+func _newPreFile(ls LuaState) *luaStream {
+	p := &luaStream{} /* mark file handle as 'closed' */
+	ls.NewUserdata(p)
+	ls.SetMetatable2(LUA_FILEHANDLE)
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#newfile()
+func _newFile(ls LuaState, f *os.File) *luaStream {
+	p := _newPreFile(ls)
+	p.file = f
+	p.closeF = ioFClose
+	runtime.SetFinalizer(p, (*luaStream).finalize)
+	return p
+}
+
+// Calls the 'close' function from a file handle.
+// lua-5.3.4/src/liolib.c#aux_close()
+func _auxClose(ls LuaState) int {
+	p := _toStream(ls)
+	cf := p.closeF
+	p.closeF = nil /* mark stream as closed */
+	return cf(ls)  /* close it */
+}
+
+// function to close regular files
+// lua-5.3.4/src/liolib.c#io_fclose()
+func ioFClose(ls LuaState) int {
+	p := _toStream(ls)
+	runtime.SetFinalizer(p, nil)
+	return _fileResult(ls, p.close(), "")
+}
+
+// function to (not) close the standard files stdin, stdout, and stderr
+// lua-5.3.4/src/liolib.c#io_noclose()
+func ioNoClose(ls LuaState) int {
+	p := _toStream(ls)
+	p.closeF = ioNoClose /* keep file opened */
+	p.flush()
+	ls.PushNil()
+	ls.PushString("cannot close standard file")
+	return 2
+}
+
+// function to close 'popen' files
+// lua-5.3.4/src/liolib.c#io_pclose()
+func ioPClose(ls LuaState) int {
+	p := _toStream(ls)
+	err := p.close()
+	if e := p.cmd.Wait(); e != nil {
+		err = e
+	}
+	return _execResult(ls, err)
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_fileresult()
+func _fileResult(ls LuaState, err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	ls.PushNil()
+	if fname != "" {
+		ls.PushString(fname + ": " + err.Error())
+	} else {
+		ls.PushString(err.Error())
+	}
+	if errno, ok := err.(syscall.Errno); ok {
+		ls.PushInteger(int64(errno))
+	} else {
+		ls.PushInteger(0)
+	}
+	return 3
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_execresult()
+func _execResult(ls LuaState, err error) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		ls.PushString("exit")
+		ls.PushInteger(0)
+		return 3
+	}
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return _fileResult(ls, err, "")
+	}
+	ls.PushNil()
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		ls.PushString("signal")
+		ls.PushInteger(int64(ws.Signal()))
+	} else {
+		ls.PushString("exit")
+		ls.PushInteger(int64(ee.ExitCode()))
+	}
+	return 3
+}
+
+// Check whether 'mode' matches '[rwa]%+?b*'.
+// lua-5.3.4/src/liolib.c#l_checkmode()
+func _checkMode(mode string) (flag int, ok bool) {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return 0, false
+	}
+	switch mode[0] {
+	case 'r':
+		flag = os.O_RDONLY
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	mode = mode[1:]
+	if mode != "" && mode[0] == '+' { /* '+' means update */
+		flag = flag&^(os.O_RDONLY|os.O_WRONLY) | os.O_RDWR
+		mode = mode[1:]
+	}
+	return flag, strings.Trim(mode, "b") == "" /* 'b' is ignored */
+}
+
+// lua-5.3.4/src/liolib.c#opencheckfile()
+func _openCheckedFile(ls LuaState, fname, mode string) {
+	flag, _ := _checkMode(mode)
+	f, err := os.OpenFile(fname, flag, 0666)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		ls.Error2("cannot open file '%s' (%s)", fname, err.Error())
+	}
+	_newFile(ls, f)
+}
+
+// lua-5.3.4/src/liolib.c#getiofile()
+func _getIOFile(ls LuaState, findex string) *luaStream {
+	ls.GetField(LUA_REGISTRYINDEX, findex)
+	p := ls.ToUserdata(-1).(*luaStream)
+	if p.isClosed() {
+		ls.Error2("standard %s file is closed", findex[len(_IO_PREFIX):])
+	}
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#g_iofile()
+func _gIOFile(ls LuaState, f, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if filename, ok := ls.ToStringX(1); ok {
+			_openCheckedFile(ls, filename, mode)
+		} else {
+			_toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(LUA_REGISTRYINDEX, f)
+	}
+	/* return current value */
+	ls.GetField(LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.close
+// lua-5.3.4/src/liolib.c#io_close()
+func ioClose(ls LuaState) int {
+	if ls.IsNone(1) { /* no argument? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_OUTPUT) /* use standard output */
+	}
+	_toFile(ls) /* make sure argument is an open stream */
+	return _auxClose(ls)
+}
+
+// __gc and __close metamethods of file handles
+// lua-5.3.4/src/liolib.c#f_gc()
+func fGC(ls LuaState) int {
+	p := _toStream(ls)
+	if !p.isClosed() && p.file != nil {
+		_auxClose(ls) /* ignore closed and incompletely open files */
+	}
+	return 0
+}
+
+// lua-5.3.4/src/liolib.c#f_tostring()
+func fToString(ls LuaState) int {
+	p := _toStream(ls)
+	if p.isClosed() {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+func ioFlush(ls LuaState) int {
+	return _fileResult(ls, _getIOFile(ls, _IO_OUTPUT).flush(), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+func fFlush(ls LuaState) int {
+	return _fileResult(ls, _toFile(ls).flush(), "")
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+func ioInput(ls LuaState) int {
+	return _gIOFile(ls, _IO_INPUT, "r")
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+func ioOutput(ls LuaState) int {
+	return _gIOFile(ls, _IO_OUTPUT, "w")
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+// lua-5.3.4/src/liolib.c#io_open()
+func ioOpen(ls LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	flag, ok := _checkMode(mode)
+	ls.ArgCheck(ok, 2, "invalid mode")
+	p := _newPreFile(ls)
+	f, err := os.OpenFile(filename, flag, 0666)
+	if err != nil {
+		return _fileResult(ls, err, filename)
+	}
+	p.file = f
+	p.closeF = ioFClose
+	runtime.SetFinalizer(p, (*luaStream).finalize)
+	return 1
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.popen
+// lua-5.3.4/src/liolib.c#io_popen()
+func ioPopen(ls LuaState) int {
+	prog := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	ls.ArgCheck(mode == "r" || mode == "w", 2, "invalid mode")
+	p := _newPreFile(ls)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", prog)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", prog)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return _fileResult(ls, err, prog)
+	}
+	if mode == "r" {
+		cmd.Stdout, p.file = pw, pr
+	} else {
+		cmd.Stdin, p.file = pr, pw
+	}
+	err = cmd.Start()
+	if mode == "r" { /* the child has its own copy of the other end */
+		pw.Close()
+	} else {
+		pr.Close()
+	}
+	if err != nil {
+		p.file.Close()
+		p.file = nil
+		return _fileResult(ls, err, prog)
+	}
+	p.cmd = cmd
+	p.closeF = ioPClose
+	return 1
+}
+
+// io.tmpfile ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.tmpfile
+// lua-5.3.4/src/liolib.c#io_tmpfile()
+func ioTmpFile(ls LuaState) int {
+	p := _newPreFile(ls)
+	f, err := ioutil.TempFile("", "lua_")
+	if err != nil {
+		return _fileResult(ls, err, "")
+	}
+	p.file = f
+	p.tmp = true
+	p.closeF = ioFClose
+	runtime.SetFinalizer(p, (*luaStream).finalize)
+	return 1
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.type
+// lua-5.3.4/src/liolib.c#io_type()
+func ioType(ls LuaState) int {
+	ls.CheckAny(1)
+	if p, ok := ls.TestUdata(1, LUA_FILEHANDLE).(*luaStream); !ok {
+		ls.PushNil() /* not a file */
+	} else if p.isClosed() {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// io.lines ([filename, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+// lua-5.3.4/src/liolib.c#io_lines()
+func ioLines(ls LuaState) int {
+	var toClose bool
+	if ls.IsNone(1) {
+		ls.PushNil() /* at least one argument */
+	}
+	if ls.IsNil(1) { /* no file name? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_INPUT) /* get default input */
+		ls.Replace(1)                             /* put it at index 1 */
+		_toFile(ls)                               /* check that it's a valid file handle */
+		toClose = false                           /* do not close it after iteration */
+	} else { /* open a new file */
+		filename := ls.CheckString(1)
+		_openCheckedFile(ls, filename, "r")
+		ls.Replace(1)  /* put file at index 1 */
+		toClose = true /* close it after iteration */
+	}
+	_auxLines(ls, toClose) /* push iteration function */
+	return 1
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+func fLines(ls LuaState) int {
+	_toFile(ls) /* check that it's a valid file handle */
+	_auxLines(ls, false)
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#aux_lines()
+func _auxLines(ls LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= _MAXARGLINE, _MAXARGLINE+2, "too many arguments")
+	ls.PushInteger(int64(n)) /* number of arguments to read */
+	ls.PushBoolean(toClose)  /* close/not close file when finished */
+	ls.Rotate(2, 2)          /* move 'n' and 'toclose' to their positions */
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+// Iteration function for 'lines'.
+// lua-5.3.4/src/liolib.c#io_readline()
+func ioReadLine(ls LuaState) int {
+	p := ls.ToUserdata(LuaUpvalueIndex(1)).(*luaStream)
+	n := int(ls.ToInteger(LuaUpvalueIndex(2)))
+	if p.isClosed() { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'g_read' */
+		ls.PushValue(LuaUpvalueIndex(3 + i))
+	}
+	n = _gRead(ls, p, 2)  /* 'n' is number of results */
+	if ls.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		/* 2nd result is error message */
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(LuaUpvalueIndex(3)) { /* generate error? */
+		ls.SetTop(0)
+		ls.PushValue(LuaUpvalueIndex(1))
+		_auxClose(ls) /* close it */
+	}
+	return 0
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+func ioRead(ls LuaState) int {
+	return _gRead(ls, _getIOFile(ls, _IO_INPUT), 1)
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+func fRead(ls LuaState) int {
+	return _gRead(ls, _toFile(ls), 2)
+}
+
+// lua-5.3.4/src/liolib.c#g_read()
+func _gRead(ls LuaState, p *luaStream, first int) int {
+	rd := p.reader()
+	nargs := ls.GetTop() - 1
+	success := true
+	var err error
+	var n int
+	if nargs == 0 { /* no arguments? */
+		success, err = _readLine(ls, rd, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		ls.CheckStack2(nargs+LUA_MINSTACK, "too many arguments")
+		for n = first; nargs > 0 && success; n, nargs = n+1, nargs-1 {
+			if ls.Type(n) == LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = _testEOF(ls, rd)
+				} else {
+					success, err = _readChars(ls, rd, l)
+				}
+			} else {
+				format := ls.CheckString(n)
+				if format != "" && format[0] == '*' {
+					format = format[1:] /* skip optional '*' (for compatibility) */
+				}
+				if format == "" {
+					return ls.ArgError(n, "invalid format")
+				}
+				switch format[0] {
+				case 'n': /* number */
+					success, err = _readNumber(ls, rd)
+				case 'l': /* line */
+					success, err = _readLine(ls, rd, true)
+				case 'L': /* line with end-of-line */
+					success, err = _readLine(ls, rd, false)
+				case 'a': /* file */
+					err = _readAll(ls, rd) /* read entire file */
+					success = true         /* always success */
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return _fileResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// lua-5.3.4/src/liolib.c#test_eof()
+func _testEOF(ls LuaState, rd *bufio.Reader) bool {
+	_, err := rd.Peek(1)
+	ls.PushString("")
+	return err == nil
+}
+
+// lua-5.3.4/src/liolib.c#read_line()
+func _readLine(ls LuaState, rd *bufio.Reader, chop bool) (bool, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	if err == nil && chop {
+		line = line[:len(line)-1] /* remove '\n' */
+	}
+	ls.PushString(line)
+	/* return ok if read something (either a newline or something else) */
+	return err == nil || line != "", nil
+}
+
+// lua-5.3.4/src/liolib.c#read_all()
+func _readAll(ls LuaState, rd *bufio.Reader) error {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	ls.PushString(string(data))
+	return nil
+}
+
+// lua-5.3.4/src/liolib.c#read_chars()
+func _readChars(ls LuaState, rd *bufio.Reader, n int64) (bool, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(rd, n))
+	if err != nil {
+		return false, err
+	}
+	ls.PushString(string(data))
+	return len(data) > 0, nil /* true iff read something */
+}
+
+/* auxiliary structure used by '_readNumber' */
+type numReader struct {
+	rd   *bufio.Reader
+	c    int    /* current character (look ahead), -1 at EOF */
+	buff []byte /* numeral being read */
+	err  error
+}
+
+// Add current char to buffer (if not out of space) and read next one
+// lua-5.3.4/src/liolib.c#nextc()
+func (self *numReader) nextc() bool {
+	if len(self.buff) >= _L_MAXLENNUM { /* buffer overflow? */
+		self.buff = nil /* invalidate result */
+		return false    /* fail */
+	}
+	self.buff = append(self.buff, byte(self.c)) /* save current char */
+	self.getc()                                 /* read next one */
+	return true
+}
+
+func (self *numReader) getc() {
+	if b, err := self.rd.ReadByte(); err == nil {
+		self.c = int(b)
+	} else {
+		if err != io.EOF {
+			self.err = err
+		}
+		self.c = -1
+	}
+}
+
+// Accept current char if it is in 'set' (of size 2)
+// lua-5.3.4/src/liolib.c#test2()
+func (self *numReader) test2(set string) bool {
+	if self.c == int(set[0]) || self.c == int(set[1]) {
+		return self.nextc()
+	}
+	return false
+}
+
+// Read a sequence of (hex)digits
+// lua-5.3.4/src/liolib.c#readdigits()
+func (self *numReader) readDigits(hex bool) int {
+	count := 0
+	for self.c >= 0 && (hex && isHexDigit(byte(self.c)) ||
+		!hex && isDigit(byte(self.c))) && self.nextc() {
+		count++
+	}
+	return count
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// Read a number: first reads a valid prefix of a numeral into a buffer.
+// Then it calls 'lua_stringtonumber' to check whether the format is
+// correct and to convert it to a Lua number
+// lua-5.3.4/src/liolib.c#read_number()
+func _readNumber(ls LuaState, rd *bufio.Reader) (bool, error) {
+	rn := &numReader{rd: rd}
+	count := 0
+	hex := false
+	rn.getc()
+	for rn.c >= 0 && strings.IndexByte(" \f\n\r\t\v", byte(rn.c)) >= 0 {
+		rn.getc() /* skip spaces */
+	}
+	rn.test2("-+")      /* optional signal */
+	if rn.test2("00") { /* optional '0x' or '0X' */
+		if rn.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += rn.readDigits(hex) /* integral part */
+	if rn.test2("..") {         /* decimal point? */
+		count += rn.readDigits(hex) /* fractional part */
+	}
+	exp := "eE"
+	if hex {
+		exp = "pP"
+	}
+	if count > 0 && rn.test2(exp) { /* exponent mark? */
+		rn.test2("-+")       /* exponent signal */
+		rn.readDigits(false) /* exponent digits */
+	}
+	if rn.c >= 0 {
+		rd.UnreadByte() /* unread look-ahead char */
+	}
+	if rn.err != nil {
+		return false, rn.err
+	}
+	if rn.buff != nil && ls.StringToNumber(string(rn.buff)) {
+		return true /* ok */, nil
+	}
+	/* invalid format */
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */, nil
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+func ioWrite(ls LuaState) int {
+	return _gWrite(ls, _getIOFile(ls, _IO_OUTPUT), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+func fWrite(ls LuaState) int {
+	p := _toFile(ls)
+	ls.PushValue(1) /* push file at the stack top (to be returned) */
+	return _gWrite(ls, p, 2)
+}
+
+// lua-5.3.4/src/liolib.c#g_write()
+func _gWrite(ls LuaState, p *luaStream, arg int) int {
+	nargs := ls.GetTop() - arg
+	var err error
+	for ; nargs > 0 && err == nil; nargs, arg = nargs-1, arg+1 {
+		if ls.Type(arg) == LUA_TNUMBER && !ls.IsInteger(arg) {
+			/* optimization: could be done exactly as for strings */
+			err = p.write(fmt.Sprintf("%.14g", ls.ToNumber(arg)))
+		} else {
+			err = p.write(ls.CheckString(arg))
+		}
+	}
+	if err == nil {
+		return 1 /* file handle already on stack top */
+	}
+	return _fileResult(ls, err, "")
+}
+
+var seekModes = []string{"set", "cur", "end"}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+// lua-5.3.4/src/liolib.c#f_seek()
+func fSeek(ls LuaState) int {
+	p := _toFile(ls)
+	op := ls.CheckOption(2, "cur", seekModes)
+	offset := ls.OptInteger(3, 0)
+	/* io.SeekStart, io.SeekCurrent and io.SeekEnd are in seekModes order */
+	pos, err := p.seek(offset, op)
+	if err != nil {
+		return _fileResult(ls, err, "") /* error */
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+var bufModes = []string{"full", "line", "no"}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+// lua-5.3.4/src/liolib.c#f_setvbuf()
+func fSetVBuf(ls LuaState) int {
+	p := _toFile(ls)
+	op := ls.CheckOption(2, "", bufModes)
+	sz := ls.OptInteger(3, LUAL_BUFFERSIZE)
+	err := p.flush()
+	if err == nil {
+		p.vbuf = op
+		p.bufSz = int(sz)
+		p.wr = nil /* the next write uses the new size */
+	}
+	return _fileResult(ls, err, "")
+}
@@ -1,6 +1,7 @@
 package stdlib
 
 import "fmt"
+import "math"
 import "strings"
 import . "github.com/tdkr/go-luavm/src/api"
 
@@ -65,11 +66,20 @@ func strRep(ls LuaState) int {
 	} else if n == 1 {
 		ls.PushString(s)
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		l, lsep := int64(len(s)), int64(len(sep))
+		if l+lsep > math.MaxInt64/n {
+			return ls.Error2("resulting string too large")
 		}
-		ls.PushString(strings.Join(a, sep))
+		ls.CheckMemory(n*l + (n-1)*lsep)
+		var b strings.Builder
+		b.Grow(int(n*l + (n-1)*lsep))
+		for i := int64(0); i < n; i++ {
+			if i > 0 {
+				b.WriteString(sep)
+			}
+			b.WriteString(s)
+		}
+		ls.PushString(b.String())
 	}
 
 	return 1
@@ -188,12 +198,15 @@ func strChar(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.dump
 // lua-5.3.4/src/lstrlib.c#str_dump()
 func strDump(ls LuaState) int {
-	// strip := ls.ToBoolean(2)
-	// ls.CheckType(1, LUA_TFUNCTION)
-	// ls.SetTop(1)
-	// ls.PushString(string(ls.Dump(strip)))
-	// return 1
-	panic("todo: strDump!")
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, LUA_TFUNCTION)
+	ls.SetTop(1)
+	chunk := ls.Dump(strip)
+	if chunk == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(chunk))
+	return 1
 }
 
 /* PACK/UNPACK */
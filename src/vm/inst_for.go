@@ -7,18 +7,9 @@ func forPrep(i Instruction, vm LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
-	if vm.Type(a) == LUA_TSTRING {
-		vm.PushNumber(vm.ToNumber(a))
-		vm.Replace(a)
-	}
-	if vm.Type(a+1) == LUA_TSTRING {
-		vm.PushNumber(vm.ToNumber(a + 1))
-		vm.Replace(a + 1)
-	}
-	if vm.Type(a+2) == LUA_TSTRING {
-		vm.PushNumber(vm.ToNumber(a + 2))
-		vm.Replace(a + 2)
-	}
+	_forNumber(vm, a, "initial value")
+	_forNumber(vm, a+1, "limit")
+	_forNumber(vm, a+2, "step")
 
 	vm.PushValue(a)
 	vm.PushValue(a + 2)
@@ -27,6 +18,21 @@ func forPrep(i Instruction, vm LuaVM) {
 	vm.AddPC(sBx)
 }
 
+// converts a control value of a numeric for loop from a string
+func _forNumber(vm LuaVM, idx int, what string) {
+	n, ok := vm.ToNumberX(idx)
+	if !ok {
+		vm.Where(0) /* position of the loop */
+		vm.PushString("'for' " + what + " must be a number")
+		vm.Concat(2)
+		vm.Error()
+	}
+	if vm.Type(idx) == LUA_TSTRING {
+		vm.PushNumber(n)
+		vm.Replace(idx)
+	}
+}
+
 // R(A)+=R(A+2);
 // if R(A) <?= R(A+1) then {
 //   pc+=sBx; R(A+3)=R(A)
@@ -62,6 +62,10 @@ func (self Instruction) CMode() byte {
 	return opcodes[self.Opcode()].argCMode
 }
 
+func (self Instruction) TestAMode() bool {
+	return opcodes[self.Opcode()].setAFlag != 0
+}
+
 func (self Instruction) Execute(vm api.LuaVM) {
 	action := opcodes[self.Opcode()].action
 	if action != nil {
@@ -70,3 +74,35 @@ func (self Instruction) Execute(vm api.LuaVM) {
 		panic(self.OpName())
 	}
 }
+
+// completes an instruction interrupted by a yield in a function
+// it called, the results of the call are on the top of the stack
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func (self Instruction) Finish(vm api.LuaVM) {
+	a, _, c := self.ABC()
+
+	switch self.Opcode() {
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR,
+		OP_MOD, OP_POW, OP_UNM, OP_BNOT, OP_LEN,
+		OP_GETTABUP, OP_GETTABLE, OP_SELF:
+		vm.Replace(a + 1)
+	case OP_EQ, OP_LT, OP_LE:
+		if vm.ToBoolean(-1) != (a != 0) { /* condition failed? */
+			vm.AddPC(1) /* skip jump instruction */
+		}
+		vm.Pop(3) /* result and operands */
+	case OP_CONCAT:
+		/* concat the elements left, may yield again */
+		vm.Concat(vm.GetTop() - vm.RegisterCount())
+		vm.Replace(a + 1)
+	case OP_CALL:
+		_popResults(a+1, c, vm)
+	case OP_TAILCALL:
+		_popResults(a+1, 0, vm)
+	case OP_TFORCALL:
+		_popResults(a+4, c+1, vm)
+	case OP_SETTABUP, OP_SETTABLE:
+		/* nothing to do */
+	}
+}